@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 
 	"github.com/mrtc0/noic/pkg/container"
+	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 )
 
@@ -31,6 +32,10 @@ Where "<container-id>" is your name for instance of the container.
 			Value: "",
 			Usage: "path to an AF_UNIX socket which will receive a file descriptor referencing the master end of the console's pseudoterminal",
 		},
+		cli.BoolFlag{
+			Name:  "ignore-unknown-caps",
+			Usage: "warn about unknown or unsupported capabilities instead of failing",
+		},
 	},
 	Action: func(context *cli.Context) error {
 		containerID := context.Args().First()
@@ -51,7 +56,7 @@ Where "<container-id>" is your name for instance of the container.
 		}
 
 		bundlePath := context.String("bundle")
-		useSystemdCgroups := context.Bool("systemd-cgroup")
+		useSystemdCgroups := context.GlobalBool("systemd-cgroup")
 
 		factory := &container.ContainerFactory{
 			ContainerID:        containerID,
@@ -59,6 +64,8 @@ Where "<container-id>" is your name for instance of the container.
 			BundlePath:         bundlePath,
 			UseSystemdCgroups:  useSystemdCgroups,
 			ConsoleSocket:      context.String("console-socket"),
+
+			IgnoreUnknownCapabilities: context.Bool("ignore-unknown-caps"),
 		}
 
 		c, err := factory.Create()
@@ -74,6 +81,12 @@ Where "<container-id>" is your name for instance of the container.
 			return fmt.Errorf("failed save state: %v", err)
 		}
 
+		// the container is still usable without, the OOM kills are then only
+		// recorded by noic state and noic events
+		if err := c.StartOOMWatcher(); err != nil {
+			logrus.Warn(err)
+		}
+
 		if context.IsSet("pid-file") {
 			if err = c.CreatePIDFile(context.String("pid-file")); err != nil {
 				return err
@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/mrtc0/noic/pkg/container"
+	"github.com/mrtc0/noic/pkg/container/cgroups"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// event is a line of the output of the events command.
+type event struct {
+	Type string      `json:"type"`
+	ID   string      `json:"id"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// oomEvent reports that the OOM killer has killed processes of a container.
+type oomEvent struct {
+	// OOMKill is the number of processes killed so far
+	OOMKill uint64 `json:"oomKill"`
+}
+
+var EventsCommand = cli.Command{
+	Name:  "events",
+	Usage: "display the resource usage and the pressure stall alerts of a container",
+	ArgsUsage: `<container-id>
+
+Where "<container-id>" is your name for instance of the container.
+	`,
+	Flags: []cli.Flag{
+		cli.DurationFlag{
+			Name:  "interval",
+			Value: 5 * time.Second,
+			Usage: "set the interval of the stats",
+		},
+		cli.BoolFlag{
+			Name:  "stats",
+			Usage: "display the stats once and exit",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		id := context.Args().First()
+		if id == "" {
+			return errors.New("container id cannnot be empty")
+		}
+
+		interval := context.Duration("interval")
+		if interval <= 0 {
+			return fmt.Errorf("invalid interval %s", interval)
+		}
+
+		stateRootDirectory := context.GlobalString("root")
+		c, err := container.FindByID(id, stateRootDirectory)
+		if err != nil {
+			return err
+		}
+
+		if c.CurrentStatus() == container.Stopped {
+			return fmt.Errorf("container is not running")
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		if context.Bool("stats") {
+			stats, err := c.Stats()
+			if err != nil {
+				return err
+			}
+			return enc.Encode(event{Type: "stats", ID: c.ID, Data: stats})
+		}
+
+		stop := make(chan struct{})
+		defer close(stop)
+
+		pressure := make(chan cgroups.PressureTrigger)
+		watchErr := make(chan error, 1)
+		go func() {
+			watchErr <- c.WatchPressure(stop, func(t cgroups.PressureTrigger) {
+				select {
+				case pressure <- t:
+				case <-stop:
+				}
+			})
+		}()
+
+		hook := c.Spec.Annotations[cgroups.AnnotationPressureHook]
+
+		var oomKills uint64
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			// the stats are reported once more after the container has
+			// exited, so that a last OOM kill is not missed
+			stopped := c.CurrentStatus() == container.Stopped
+
+			stats, err := c.Stats()
+			if err != nil {
+				// the cgroup is gone when the container has exited meanwhile
+				if stopped || c.CurrentStatus() == container.Stopped {
+					return nil
+				}
+				return err
+			}
+
+			if err := enc.Encode(event{Type: "stats", ID: c.ID, Data: stats}); err != nil {
+				return err
+			}
+
+			if oomKill := stats.Memory.Events.OOMKill; oomKill > oomKills {
+				oomKills = oomKill
+				if err := c.UpdateOOMKilled(stats); err != nil {
+					return err
+				}
+				if err := enc.Encode(event{Type: "oom", ID: c.ID, Data: oomEvent{OOMKill: oomKill}}); err != nil {
+					return err
+				}
+			}
+
+			if stopped {
+				return nil
+			}
+
+		wait:
+			for {
+				select {
+				case t := <-pressure:
+					e := event{Type: "pressure", ID: c.ID, Data: t}
+					if err := enc.Encode(e); err != nil {
+						return err
+					}
+					if hook != "" {
+						if err := runPressureHook(hook, e); err != nil {
+							logrus.Warnf("failed run pressure hook: %s", err)
+						}
+					}
+				case err := <-watchErr:
+					if err != nil {
+						return err
+					}
+					// the watch is done, either without triggers or with the cgroup
+					watchErr = nil
+				case <-ticker.C:
+					break wait
+				}
+			}
+		}
+	},
+}
+
+// runPressureHook runs the executable at path with e on the standard input.
+func runPressureHook(path string, e event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(path)
+	cmd.Stdin = bytes.NewReader(b)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
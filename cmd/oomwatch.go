@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"errors"
+
+	"github.com/mrtc0/noic/pkg/container"
+	"github.com/urfave/cli"
+)
+
+// OOMWatchCommand is started by create to record an OOM kill of the container
+// in its state, even when the cgroup is removed as the container exits.
+var OOMWatchCommand = cli.Command{
+	Name:   "oom-watch",
+	Usage:  "record an OOM kill of a container (internal)",
+	Hidden: true,
+	Action: func(context *cli.Context) error {
+		id := context.Args().First()
+		if id == "" {
+			return errors.New("container id cannnot be empty")
+		}
+
+		c, err := container.FindByID(id, context.GlobalString("root"))
+		if err != nil {
+			return err
+		}
+
+		return c.WatchOOMKill()
+	},
+}
@@ -6,10 +6,19 @@ import (
 	"fmt"
 
 	"github.com/mrtc0/noic/pkg/container"
+	"github.com/mrtc0/noic/pkg/container/apparmor"
 	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 )
 
+type containerState struct {
+	specs.State
+	ApparmorProfile string `json:"apparmorProfile,omitempty"`
+	ApparmorMode    string `json:"apparmorMode,omitempty"`
+	OOMKilled       bool   `json:"oomKilled,omitempty"`
+}
+
 var StateCommand = cli.Command{
 	Name:  "state",
 	Usage: "state of contaienr",
@@ -30,7 +39,26 @@ Where "<container-id>" is your name for instance of the container.
 		}
 
 		c.State.Status = specs.ContainerState(c.CurrentStatus().String())
-		j, err := json.Marshal(c.State)
+		state := containerState{State: c.State}
+		if c.ApparmorProfile != nil {
+			state.ApparmorProfile = c.ApparmorProfile.Name
+			// the mode can be changed after create by reloading the profile,
+			// so it is looked up again and the mode at create is only
+			// reported when the profile cannot be read anymore
+			state.ApparmorMode = c.ApparmorProfile.Mode
+			if p, err := apparmor.LookupProfile(c.ApparmorProfile.Name); err == nil {
+				state.ApparmorMode = p.Mode
+			}
+		}
+
+		// the state is still reported when the memory events cannot be read
+		oomKilled, err := c.CheckOOMKilled()
+		if err != nil {
+			logrus.Warnf("failed check OOM kills: %s", err)
+		}
+		state.OOMKilled = oomKilled
+
+		j, err := json.Marshal(state)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/mrtc0/noic/pkg/container"
+	"github.com/urfave/cli"
+)
+
+var StatsCommand = cli.Command{
+	Name:  "stats",
+	Usage: "display the resource usage of a container",
+	ArgsUsage: `<container-id>
+
+Where "<container-id>" is your name for instance of the container.
+	`,
+	Action: func(context *cli.Context) error {
+		id := context.Args().First()
+		if id == "" {
+			return errors.New("container id cannnot be empty")
+		}
+
+		stateRootDirectory := context.GlobalString("root")
+		c, err := container.FindByID(id, stateRootDirectory)
+		if err != nil {
+			return err
+		}
+
+		if c.CurrentStatus() == container.Stopped {
+			return fmt.Errorf("container is not running")
+		}
+
+		stats, err := c.Stats()
+		if err != nil {
+			return err
+		}
+
+		j, err := json.Marshal(stats)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(j))
+
+		return nil
+	},
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mrtc0/noic/pkg/container"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/urfave/cli"
+)
+
+var UpdateCommand = cli.Command{
+	Name:  "update",
+	Usage: "update container resource constraints",
+	ArgsUsage: `<container-id>
+
+Where "<container-id>" is your name for instance of the container.
+	`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "resources, r",
+			Usage: `path to the file containing the resources to update or '-' to read from the standard input`,
+		},
+		cli.StringSliceFlag{
+			Name:  "unified",
+			Usage: "set a cgroup v2 unified resource, in the form key=value (can be specified multiple times)",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		id := context.Args().First()
+		if id == "" {
+			return errors.New("container id cannnot be empty")
+		}
+
+		stateRootDirectory := context.GlobalString("root")
+		c, err := container.FindByID(id, stateRootDirectory)
+		if err != nil {
+			return err
+		}
+
+		if c.CurrentStatus() == container.Stopped {
+			return fmt.Errorf("container is not running")
+		}
+
+		resources := &specs.LinuxResources{}
+		if c.Spec.Linux.Resources != nil {
+			resources = c.Spec.Linux.Resources
+		}
+
+		if path := context.String("resources"); path != "" {
+			if err := readResources(path, resources); err != nil {
+				return err
+			}
+		}
+
+		for _, u := range context.StringSlice("unified") {
+			key, value, ok := strings.Cut(u, "=")
+			if !ok {
+				return fmt.Errorf("invalid --unified %q: expect the format key=value", u)
+			}
+
+			if resources.Unified == nil {
+				resources.Unified = map[string]string{}
+			}
+			resources.Unified[key] = value
+		}
+
+		return c.Update(resources)
+	},
+}
+
+// readResources decodes the resources in path over resources.
+func readResources(path string, resources *specs.LinuxResources) error {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	if err := json.NewDecoder(r).Decode(resources); err != nil {
+		return fmt.Errorf("failed decode resources: %s", err)
+	}
+
+	return nil
+}
@@ -3,7 +3,10 @@ module github.com/mrtc0/noic
 go 1.19
 
 require (
+	github.com/cilium/ebpf v0.4.0
 	github.com/containerd/cgroups v1.0.4
+	github.com/coreos/go-systemd/v22 v22.3.2
+	github.com/godbus/dbus/v5 v5.0.4
 	github.com/opencontainers/runtime-spec v1.0.3-0.20220909204839-494a5a6aca78
 	github.com/seccomp/libseccomp-golang v0.10.0
 	github.com/shirou/gopsutil v3.21.11+incompatible
@@ -15,13 +18,10 @@ require (
 )
 
 require (
-	github.com/cilium/ebpf v0.4.0 // indirect
-	github.com/coreos/go-systemd/v22 v22.3.2 // indirect
 	github.com/cpuguy83/go-md2man/v2 v2.0.0-20190314233015-f79a8a8ca69d // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/docker/go-units v0.4.0 // indirect
 	github.com/go-ole/go-ole v1.2.6 // indirect
-	github.com/godbus/dbus/v5 v5.0.4 // indirect
 	github.com/gogo/protobuf v1.3.2 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/russross/blackfriday/v2 v2.0.1 // indirect
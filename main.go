@@ -87,6 +87,10 @@ func main() {
 		cmd.KillCommand,
 		cmd.StateCommand,
 		cmd.ExecCommand,
+		cmd.UpdateCommand,
+		cmd.StatsCommand,
+		cmd.EventsCommand,
+		cmd.OOMWatchCommand,
 	}
 
 	app.Before = func(context *cli.Context) error {
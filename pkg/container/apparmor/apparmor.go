@@ -1,28 +1,82 @@
 package apparmor
 
 import (
-	"errors"
+	"bufio"
 	"fmt"
+	"io"
 	"os"
+	"strings"
+
+	"github.com/mrtc0/noic/pkg/container/lsm"
 )
 
-func ApplyProfile(name string) error {
-	if name == "" {
-		return nil
+const (
+	apparmorEnabledPath  = "/sys/module/apparmor/parameters/enabled"
+	apparmorProfilesPath = "/sys/kernel/security/apparmor/profiles"
+)
+
+type Profile struct {
+	Name string `json:"name"`
+	Mode string `json:"mode"`
+}
+
+func IsEnabled() bool {
+	if _, err := os.Stat("/sys/kernel/security/apparmor"); err != nil {
+		return false
 	}
 
-	apparmorExecPath := "/proc/self/attr/apparmor/exec"
-	// apparmorExecPath := fmt.Sprintf("/proc/%d/attr/apparmor/exec", pid)
-	if _, err := os.Stat(apparmorExecPath); errors.Is(err, os.ErrNotExist) {
-		apparmorExecPath = "/proc/self/attr/exec"
+	b, err := os.ReadFile(apparmorEnabledPath)
+	if err != nil {
+		return false
 	}
 
-	f, err := os.OpenFile(apparmorExecPath, os.O_WRONLY, 0)
+	return strings.HasPrefix(string(b), "Y")
+}
+
+// LookupProfile returns the named profile and its mode (enforce, complain, ...) if it is loaded.
+func LookupProfile(name string) (*Profile, error) {
+	if !IsEnabled() {
+		return nil, fmt.Errorf("apparmor is not enabled on this host, cannot apply profile %s", name)
+	}
+
+	f, err := os.Open(apparmorProfilesPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer f.Close()
 
-	_, err = f.WriteString(fmt.Sprintf("exec %s", name))
-	return err
+	return lookupProfile(f, name)
+}
+
+// lookupProfile finds the named profile in r, which lists the loaded profiles
+// in the format of apparmorProfilesPath.
+func lookupProfile(r io.Reader, name string) (*Profile, error) {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		// e.g. "docker-default (enforce)"
+		line := s.Text()
+		i := strings.LastIndex(line, " (")
+		if i < 0 || line[:i] != name {
+			continue
+		}
+
+		return &Profile{Name: name, Mode: strings.TrimSuffix(line[i+2:], ")")}, nil
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, fmt.Errorf("apparmor profile %s is not loaded", name)
+}
+
+func ApplyProfile(name string) error {
+	if name == "" {
+		return nil
+	}
+
+	if err := lsm.WriteExecAttr("apparmor", "exec "+name); err != nil {
+		return fmt.Errorf("failed apply apparmor profile %s: %s", name, err)
+	}
+
+	return nil
 }
@@ -0,0 +1,61 @@
+package apparmor
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupProfile(t *testing.T) {
+	profiles := `docker-default (enforce)
+my profile (complain)
+/usr/bin/man (kill)
+docker-default//child (enforce)
+`
+
+	tests := []struct {
+		name    string
+		profile string
+		want    *Profile
+		wantErr bool
+	}{
+		{
+			name:    "enforce",
+			profile: "docker-default",
+			want:    &Profile{Name: "docker-default", Mode: "enforce"},
+		},
+		{
+			name:    "name with spaces",
+			profile: "my profile",
+			want:    &Profile{Name: "my profile", Mode: "complain"},
+		},
+		{
+			name:    "kill",
+			profile: "/usr/bin/man",
+			want:    &Profile{Name: "/usr/bin/man", Mode: "kill"},
+		},
+		{
+			name:    "not loaded",
+			profile: "unknown",
+			wantErr: true,
+		},
+		{
+			name:    "prefix of a loaded profile",
+			profile: "docker",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := lookupProfile(strings.NewReader(profiles), tt.profile)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
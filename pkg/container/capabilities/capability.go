@@ -1,10 +1,13 @@
 package capabilities
 
 import (
+	"fmt"
 	"strings"
 
 	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
 	"github.com/syndtr/gocapability/capability"
+	"golang.org/x/sys/unix"
 )
 
 const allCapabilityTypes = capability.CAPS | capability.BOUNDING | capability.AMBIENT
@@ -18,14 +21,22 @@ var (
 		capability.AMBIENT,
 	}
 
-	giveCaps = map[capability.CapType][]capability.Cap{
-		capability.BOUNDING:  {capability.CAP_CHOWN, capability.CAP_DAC_OVERRIDE, capability.CAP_FOWNER, capability.CAP_KILL, capability.CAP_FSETID, capability.CAP_SETGID, capability.CAP_SETUID, capability.CAP_SETFCAP},
-		capability.PERMITTED: {capability.CAP_CHOWN, capability.CAP_DAC_OVERRIDE, capability.CAP_FOWNER, capability.CAP_KILL, capability.CAP_FSETID, capability.CAP_SETGID, capability.CAP_SETUID, capability.CAP_SETFCAP},
-		// capability.INHERITABLE: {capability.CAP_CHOWN, capability.CAP_DAC_OVERRIDE, capability.CAP_FOWNER, capability.CAP_KILL, capability.CAP_FSETID, capability.CAP_SETGID, capability.CAP_SETUID, capability.CAP_SETFCAP},
-		capability.INHERITABLE: {},
-		// cap_chown,cap_dac_override,cap_fowner,cap_fsetid,cap_kill,cap_setgid,cap_setuid,cap_setpcap,cap_net_bind_service,cap_net_raw,cap_sys_chroot,cap_mknod,cap_audit_write,cap_setfcap=ep
-		capability.EFFECTIVE: {capability.CAP_CHOWN, capability.CAP_DAC_OVERRIDE, capability.CAP_FOWNER, capability.CAP_KILL, capability.CAP_FSETID, capability.CAP_SETGID, capability.CAP_SETUID, capability.CAP_SETFCAP},
-		capability.AMBIENT:   {capability.CAP_CHOWN, capability.CAP_DAC_OVERRIDE, capability.CAP_FOWNER, capability.CAP_KILL, capability.CAP_FSETID, capability.CAP_SETGID, capability.CAP_SETUID, capability.CAP_SETFCAP},
+	// defaultCapabilities is used only when the spec omits process.capabilities.
+	defaultCapabilities = []string{
+		"CAP_CHOWN",
+		"CAP_DAC_OVERRIDE",
+		"CAP_FOWNER",
+		"CAP_FSETID",
+		"CAP_KILL",
+		"CAP_SETGID",
+		"CAP_SETUID",
+		"CAP_SETPCAP",
+		"CAP_NET_BIND_SERVICE",
+		"CAP_NET_RAW",
+		"CAP_SYS_CHROOT",
+		"CAP_MKNOD",
+		"CAP_AUDIT_WRITE",
+		"CAP_SETFCAP",
 	}
 )
 
@@ -33,6 +44,14 @@ type CapabilityConfig struct {
 	caps map[capability.CapType][]capability.Cap
 }
 
+func DefaultCapabilities() specs.LinuxCapabilities {
+	return specs.LinuxCapabilities{
+		Bounding:  defaultCapabilities,
+		Effective: defaultCapabilities,
+		Permitted: defaultCapabilities,
+	}
+}
+
 func newCapabilityMap() map[string]capability.Cap {
 	capabilityMap := map[string]capability.Cap{}
 
@@ -44,31 +63,73 @@ func newCapabilityMap() map[string]capability.Cap {
 	return capabilityMap
 }
 
-func stringToCapabilities(caps []string) []capability.Cap {
+func stringToCapabilities(caps []string, ignoreUnknown bool) ([]capability.Cap, error) {
 	capabilities := []capability.Cap{}
 	capabilityMap := newCapabilityMap()
 
 	for _, c := range caps {
-		capabilities = append(capabilities, capabilityMap[c])
+		cap, exists := capabilityMap[c]
+		if !exists {
+			if ignoreUnknown {
+				logrus.Warnf("ignoring unknown capability: %s", c)
+				continue
+			}
+			return nil, fmt.Errorf("unknown capability: %s", c)
+		}
+
+		if cap > capability.CAP_LAST_CAP {
+			if ignoreUnknown {
+				logrus.Warnf("ignoring capability not supported by the running kernel: %s", c)
+				continue
+			}
+			return nil, fmt.Errorf("capability %s is not supported by the running kernel", c)
+		}
+
+		capabilities = append(capabilities, cap)
 	}
 
-	return capabilities
+	return capabilities, nil
 }
 
-func New(capabilities specs.LinuxCapabilities) *CapabilityConfig {
-	caps := map[capability.CapType][]capability.Cap{
-		capability.BOUNDING:    stringToCapabilities(capabilities.Bounding),
-		capability.AMBIENT:     stringToCapabilities(capabilities.Ambient),
-		capability.EFFECTIVE:   stringToCapabilities(capabilities.Effective),
-		capability.INHERITABLE: stringToCapabilities(capabilities.Inheritable),
-		capability.PERMITTED:   stringToCapabilities(capabilities.Permitted),
+func New(capabilities *specs.LinuxCapabilities, ignoreUnknown bool) (*CapabilityConfig, error) {
+	if capabilities == nil {
+		c := DefaultCapabilities()
+		capabilities = &c
+	}
+
+	sets := map[capability.CapType][]string{
+		capability.BOUNDING:    capabilities.Bounding,
+		capability.AMBIENT:     capabilities.Ambient,
+		capability.EFFECTIVE:   capabilities.Effective,
+		capability.INHERITABLE: capabilities.Inheritable,
+		capability.PERMITTED:   capabilities.Permitted,
 	}
 
-	return &CapabilityConfig{caps: caps}
+	caps := map[capability.CapType][]capability.Cap{}
+	for capType, names := range sets {
+		c, err := stringToCapabilities(names, ignoreUnknown)
+		if err != nil {
+			return nil, err
+		}
+		caps[capType] = c
+	}
+
+	return &CapabilityConfig{caps: caps}, nil
 }
 
-func (c CapabilityConfig) Apply() error {
+// ApplyBounding drops every capability missing from the bounding set.
+// It needs CAP_SETPCAP, so it must run before switching to the container user.
+func (c CapabilityConfig) ApplyBounding() error {
+	return c.apply(capability.BOUNDING)
+}
+
+// ApplyCaps sets the effective, permitted, inheritable and ambient sets.
+// It must run after switching to the container user.
+func (c CapabilityConfig) ApplyCaps() error {
+	return c.apply(capability.CAPS | capability.AMBIENT)
+}
 
+func (c CapabilityConfig) apply(kind capability.CapType) error {
 	caps, err := capability.NewPid2(0)
 	if err != nil {
 		return err
@@ -79,9 +140,26 @@ func (c CapabilityConfig) Apply() error {
 		caps.Set(capType, c.caps[capType]...)
 	}
 
-	if err := caps.Apply(allCapabilityTypes); err != nil {
+	if err := caps.Apply(kind); err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// SetKeepCaps keeps the permitted set across the setuid transition.
+func SetKeepCaps() error {
+	if err := unix.Prctl(unix.PR_SET_KEEPCAPS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed PR_SET_KEEPCAPS: %s", err)
+	}
+
+	return nil
+}
+
+func ClearKeepCaps() error {
+	if err := unix.Prctl(unix.PR_SET_KEEPCAPS, 0, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed PR_SET_KEEPCAPS: %s", err)
+	}
+
+	return nil
+}
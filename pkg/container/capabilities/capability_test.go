@@ -0,0 +1,71 @@
+package capabilities
+
+import (
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/syndtr/gocapability/capability"
+)
+
+func TestStringToCapabilities(t *testing.T) {
+	lastCap := capability.CAP_LAST_CAP
+	capability.CAP_LAST_CAP = capability.CAP_AUDIT_READ
+	defer func() { capability.CAP_LAST_CAP = lastCap }()
+
+	testCases := []struct {
+		name          string
+		caps          []string
+		ignoreUnknown bool
+		expected      []capability.Cap
+		expectError   bool
+	}{
+		{
+			name:     "known capabilities",
+			caps:     []string{"CAP_CHOWN", "CAP_NET_RAW"},
+			expected: []capability.Cap{capability.CAP_CHOWN, capability.CAP_NET_RAW},
+		},
+		{
+			name:        "unknown capability",
+			caps:        []string{"CAP_CHOWN", "CAP_NET_RAWW"},
+			expectError: true,
+		},
+		{
+			name:        "capability not supported by the kernel",
+			caps:        []string{"CAP_BPF"},
+			expectError: true,
+		},
+		{
+			name:          "ignore unknown capabilities",
+			caps:          []string{"CAP_NET_RAWW", "CAP_KILL", "CAP_BPF"},
+			ignoreUnknown: true,
+			expected:      []capability.Cap{capability.CAP_KILL},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			caps, err := stringToCapabilities(test.caps, test.ignoreUnknown)
+			if test.expectError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, caps)
+		})
+	}
+}
+
+func TestNew_DefaultCapabilities(t *testing.T) {
+	c, err := New(nil, false)
+	assert.NoError(t, err)
+
+	assert.Contains(t, c.caps[capability.BOUNDING], capability.CAP_CHOWN)
+	assert.NotContains(t, c.caps[capability.BOUNDING], capability.CAP_SYS_ADMIN)
+	assert.Empty(t, c.caps[capability.INHERITABLE])
+	assert.Empty(t, c.caps[capability.AMBIENT])
+
+	_, err = New(&specs.LinuxCapabilities{Bounding: []string{"CAP_UNKNOWN"}}, false)
+	assert.Error(t, err)
+}
@@ -1,20 +1,36 @@
 package cgroups
 
 import (
+	"errors"
 	"fmt"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 
 	cgroupsv1 "github.com/containerd/cgroups"
 	cgroupsv2 "github.com/containerd/cgroups/v2"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
 )
 
+const unifiedMountpoint = "/sys/fs/cgroup"
+
+// AnnotationOOMGroup set to false lets the OOM killer kill single processes of
+// the container instead of all of them. It only has an effect on cgroup v2.
+const AnnotationOOMGroup = "org.noic.memory.oom-group"
+
+var errUnifiedOnV1 = errors.New("unified resources are only supported on cgroup v2")
+
 const defaultMountFlags = syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
 
 type Manager struct {
 	v1 cgroupsv1.Cgroup
 	v2 *cgroupsv2.Manager
+
+	// path is the directory of the cgroup v2
+	path  string
+	state State
 }
 
 type CgroupConfig struct {
@@ -23,6 +39,9 @@ type CgroupConfig struct {
 	Resources  *specs.LinuxResources
 	Name       string
 	Pid        int
+	// OOMGroup makes the OOM killer kill all the processes of the cgroup
+	// together
+	OOMGroup bool
 
 	scopePrefix string
 	parent      string
@@ -32,48 +51,237 @@ func IsVersion2() bool {
 	return cgroupsv1.Mode() == cgroupsv1.Unified
 }
 
+// New creates the cgroup of the container, applies the resource limits and
+// moves config.Pid into it. The cgroup is created through systemd when
+// config.UseSystemd is set, and directly on the cgroup filesystem otherwise.
+// The cgroup is removed again when any of the steps fails.
 func New(config *CgroupConfig) (*Manager, error) {
-	if config.CgroupPath == "" {
-		config.scopePrefix = "noic"
-		config.parent = "/"
+	if !IsVersion2() {
+		return newV1(config)
+	}
+
+	values, err := toV2Values(config.Resources)
+	if err != nil {
+		return nil, err
+	}
+
+	var m *Manager
+	if config.UseSystemd {
+		m, err = newSystemd(config)
 	} else {
-		// e.g. system.slice:docker:123456
-		parts := strings.Split(config.CgroupPath, ":")
-		if len(parts) != 3 {
-			return nil, fmt.Errorf("expect cgroupsPath to be format \"slice:prefix:name\"")
+		m, err = newFs(config)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.setupV2(values, config); err != nil {
+		if err := Destroy(m.state); err != nil {
+			logrus.Warnf("failed remove cgroup %s: %s", m.state.Path, err)
 		}
+		return nil, err
+	}
 
-		config.parent = parts[0]
-		config.scopePrefix = parts[1]
-		config.Name = parts[2]
+	return m, nil
+}
+
+func (m *Manager) setupV2(values []v2Value, config *CgroupConfig) error {
+	if err := m.setV2Resources(values, config.Resources); err != nil {
+		return err
 	}
 
-	r := cgroupsv2.ToResources(config.Resources)
-	// Workaround.
-	// https://github.com/containerd/cgroups/blob/724eb82fe759f3b3b9c5f07d22d2fab93467dc56/v2/utils.go#L164
-	if shares := config.Resources.CPU.Shares; shares != nil {
-		convertedWeight := 1 + ((*shares)*9999)/262142
-		w := uint64(convertedWeight)
-		r.CPU.Weight = &w
+	// memory.oom.group in the unified resources takes precedence
+	if _, ok := config.Resources.Unified["memory.oom.group"]; config.OOMGroup && !ok {
+		return m.setOOMGroup()
 	}
 
-	m, err := cgroupsv2.NewSystemd("", getUnitName(config), config.Pid, r)
+	return nil
+}
+
+// setOOMGroup makes the OOM killer kill all the processes of the cgroup
+// together. It is skipped when the host has no memory controller.
+func (m *Manager) setOOMGroup() error {
+	controllers, err := m.v2.RootControllers()
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if !containsString(controllers, "memory") {
+		logrus.Warn("the memory controller is not available, memory.oom.group is not set")
+		return nil
 	}
 
-	return &Manager{v2: m}, nil
+	// memory.oom.group only exists in the cgroup with the memory controller enabled
+	if err := m.v2.ToggleControllers([]string{"memory"}, cgroupsv2.Enable); err != nil {
+		return err
+	}
+
+	return writeV2Values(m.path, []v2Value{{file: "memory.oom.group", value: "1"}})
+}
+
+func containsString(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+// OOMGroupFromAnnotations returns whether the OOM killer kills the container as
+// a whole, which is the default.
+func OOMGroupFromAnnotations(annotations map[string]string) (bool, error) {
+	v, ok := annotations[AnnotationOOMGroup]
+	if !ok {
+		return true, nil
+	}
+
+	oomGroup, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid annotation %s: %q is not a boolean", AnnotationOOMGroup, v)
+	}
+
+	return oomGroup, nil
+}
 
-	// m, err := cgroupsv2.NewManager(path, fmt.Sprintf("/%s-cgroup.slice", name), cgroupsv2.ToResources(&resources))
-	/*
+// Update applies resources to the cgroup.
+func (m *Manager) Update(resources *specs.LinuxResources) error {
+	if m.v1 != nil {
+		if len(resources.Unified) > 0 {
+			return errUnifiedOnV1
 		}
-		control, err := cgroupsv1.New(cgroupsv1.V1, cgroupsv1.StaticPath(name), &resources)
-		if err != nil {
-			return nil, err
+		return m.v1.Update(v1Resources(resources))
+	}
+
+	values, err := toV2Values(resources)
+	if err != nil {
+		return err
+	}
+
+	return m.setV2Resources(values, resources)
+}
+
+// setV2Resources writes values, the translation of resources, to the cgroup
+// followed by the device filter and the unified resources.
+func (m *Manager) setV2Resources(values []v2Value, resources *specs.LinuxResources) error {
+	if controllers := v2Controllers(values); len(controllers) > 0 {
+		if err := m.v2.ToggleControllers(controllers, cgroupsv2.Enable); err != nil {
+			return err
 		}
+	}
+
+	if err := writeV2Values(m.path, values); err != nil {
+		return err
+	}
+
+	// the device controller of cgroup v2 is an eBPF program
+	if err := setDevices(m.path, resources.Devices); err != nil {
+		return err
+	}
+
+	return m.setUnified(resources.Unified)
+}
+
+// setUnified enables the controllers of the unified resources and writes them.
+func (m *Manager) setUnified(unified map[string]string) error {
+	if len(unified) == 0 {
+		return nil
+	}
+
+	controllers := unifiedControllers(unified)
+	if err := m.v2.ToggleControllers(controllers, cgroupsv2.Enable); err != nil {
+		// an unknown or unavailable controller is reported with its key
+		if verr := validateUnified(m.path, unified); verr != nil {
+			return fmt.Errorf("%w (failed enable controllers: %s)", verr, err)
+		}
+		return fmt.Errorf("failed enable controllers %s: %w", strings.Join(controllers, " "), err)
+	}
+
+	return setUnified(m.path, unified)
+}
+
+// newFs creates the cgroup at cgroupsPath under the cgroup v2 mount point.
+func newFs(config *CgroupConfig) (*Manager, error) {
+	group := fsGroupPath(config)
+	m, err := cgroupsv2.NewManager(unifiedMountpoint, group, &cgroupsv2.Resources{})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.AddProc(uint64(config.Pid)); err != nil {
+		m.Delete()
+		return nil, err
+	}
+
+	return &Manager{
+		v2:    m,
+		path:  filepath.Join(unifiedMountpoint, group),
+		state: State{Path: group},
+	}, nil
+}
+
+func newSystemd(config *CgroupConfig) (*Manager, error) {
+	if err := parseSystemdPath(config); err != nil {
+		return nil, err
+	}
+
+	// the unit is started here rather than with cgroupsv2.NewSystemd, which
+	// ignores the slice
+	unit := getUnitName(config)
+	if err := startUnit(config.parent, unit, config.Pid); err != nil {
+		return nil, err
+	}
+
+	// systemd has moved the pid into the cgroup of the unit
+	group, err := cgroupsv2.PidGroupPath(config.Pid)
+	if err != nil {
+		stopUnit(unit)
+		return nil, err
+	}
+
+	m, err := cgroupsv2.LoadManager(unifiedMountpoint, group)
+	if err != nil {
+		stopUnit(unit)
+		return nil, err
+	}
+
+	return &Manager{
+		v2:    m,
+		path:  filepath.Join(unifiedMountpoint, group),
+		state: State{Path: group, Slice: config.parent, Unit: unit},
+	}, nil
+}
+
+// parseSystemdPath sets the slice and the unit name of the container from
+// cgroupsPath. Without a cgroupsPath, the parent is empty for the default
+// slice.
+func parseSystemdPath(config *CgroupConfig) error {
+	if config.CgroupPath == "" {
+		config.scopePrefix = "noic"
+		config.parent = ""
+		return nil
+	}
+
+	// e.g. system.slice:docker:123456
+	parts := strings.Split(config.CgroupPath, ":")
+	if len(parts) != 3 {
+		return fmt.Errorf("expect cgroupsPath to be format \"slice:prefix:name\"")
+	}
+
+	config.parent = parts[0]
+	config.scopePrefix = parts[1]
+	config.Name = parts[2]
+	return nil
+}
+
+// fsGroupPath returns the cgroupsPath as a path relative to the cgroup mount
+// point. Without a cgroupsPath, the cgroup is named after the container.
+func fsGroupPath(config *CgroupConfig) string {
+	if config.CgroupPath == "" {
+		return filepath.Join("/noic", config.Name)
+	}
 
-		return &Manager{v1: control}, nil
-	*/
+	return filepath.Join("/", config.CgroupPath)
 }
 
 func (m Manager) Add(pid uint64) error {
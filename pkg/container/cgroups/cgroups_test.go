@@ -0,0 +1,139 @@
+package cgroups
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFsGroupPath(t *testing.T) {
+	tests := []struct {
+		name   string
+		config CgroupConfig
+		want   string
+	}{
+		{
+			name:   "no cgroupsPath",
+			config: CgroupConfig{Name: "container1"},
+			want:   "/noic/container1",
+		},
+		{
+			name:   "absolute cgroupsPath",
+			config: CgroupConfig{Name: "container1", CgroupPath: "/my/cgroup"},
+			want:   "/my/cgroup",
+		},
+		{
+			name:   "relative cgroupsPath",
+			config: CgroupConfig{Name: "container1", CgroupPath: "my/cgroup/"},
+			want:   "/my/cgroup",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, fsGroupPath(&tt.config))
+		})
+	}
+}
+
+func TestParseSystemdPath(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     CgroupConfig
+		wantParent string
+		wantUnit   string
+		wantErr    bool
+	}{
+		{
+			name:       "no cgroupsPath",
+			config:     CgroupConfig{Name: "container1"},
+			wantParent: "",
+			wantUnit:   "noic-container1.scope",
+		},
+		{
+			name:       "slice:prefix:name",
+			config:     CgroupConfig{Name: "container1", CgroupPath: "system.slice:docker:123456"},
+			wantParent: "system.slice",
+			wantUnit:   "docker-123456.scope",
+		},
+		{
+			name:       "slice as the name",
+			config:     CgroupConfig{Name: "container1", CgroupPath: "system.slice:docker:my.slice"},
+			wantParent: "system.slice",
+			wantUnit:   "my.slice",
+		},
+		{
+			name:    "invalid cgroupsPath",
+			config:  CgroupConfig{Name: "container1", CgroupPath: "/my/cgroup"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := parseSystemdPath(&tt.config)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantParent, tt.config.parent)
+			assert.Equal(t, tt.wantUnit, getUnitName(&tt.config))
+		})
+	}
+}
+
+func TestOOMGroupFromAnnotations(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        bool
+		wantErr     bool
+	}{
+		{name: "default", annotations: map[string]string{}, want: true},
+		{name: "enabled", annotations: map[string]string{AnnotationOOMGroup: "true"}, want: true},
+		{name: "disabled", annotations: map[string]string{AnnotationOOMGroup: "false"}, want: false},
+		{name: "invalid", annotations: map[string]string{AnnotationOOMGroup: "sometimes"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := OOMGroupFromAnnotations(tt.annotations)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNew_RemovedOnError(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+
+	config := &CgroupConfig{
+		CgroupPath: filepath.Join("noic-test", filepath.Base(t.TempDir())),
+		Resources:  &specs.LinuxResources{},
+		Name:       "t1",
+		// no such process, so that moving it into the cgroup fails
+		Pid: math.MaxInt32,
+	}
+
+	_, err := New(config)
+	require.Error(t, err)
+
+	dir := filepath.Join(unifiedMountpoint, config.CgroupPath)
+	if !IsVersion2() {
+		dir = filepath.Join(unifiedMountpoint, "pids", config.CgroupPath)
+	}
+	_, err = os.Stat(dir)
+	assert.True(t, os.IsNotExist(err), "cgroup %s is left behind", dir)
+}
@@ -0,0 +1,292 @@
+package cgroups
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"unsafe"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/link"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+// deviceFilterLicense is the license of the device filter program. The program
+// does not call any GPL-only helpers.
+const deviceFilterLicense = "Apache"
+
+// defaultDeviceRules allow the default devices of the runtime-spec and the
+// pseudo terminals, which every container needs whatever its device rules are.
+var defaultDeviceRules = []specs.LinuxDeviceCgroup{
+	// null, zero, full, random and urandom
+	{Allow: true, Type: "c", Major: int64Ptr(1), Minor: int64Ptr(3), Access: "rwm"},
+	{Allow: true, Type: "c", Major: int64Ptr(1), Minor: int64Ptr(5), Access: "rwm"},
+	{Allow: true, Type: "c", Major: int64Ptr(1), Minor: int64Ptr(7), Access: "rwm"},
+	{Allow: true, Type: "c", Major: int64Ptr(1), Minor: int64Ptr(8), Access: "rwm"},
+	{Allow: true, Type: "c", Major: int64Ptr(1), Minor: int64Ptr(9), Access: "rwm"},
+	// tty and ptmx
+	{Allow: true, Type: "c", Major: int64Ptr(5), Minor: int64Ptr(0), Access: "rwm"},
+	{Allow: true, Type: "c", Major: int64Ptr(5), Minor: int64Ptr(2), Access: "rwm"},
+	// the pseudo terminals in /dev/pts
+	{Allow: true, Type: "c", Major: int64Ptr(136), Minor: int64Ptr(-1), Access: "rwm"},
+}
+
+func int64Ptr(i int64) *int64 {
+	return &i
+}
+
+// denyAllDevices is the rule the device rules start with when the spec has
+// none, so that only defaultDeviceRules are allowed.
+var denyAllDevices = specs.LinuxDeviceCgroup{Allow: false, Type: "a", Access: "rwm"}
+
+// withDefaultDeviceRules appends defaultDeviceRules to devices, so that they
+// take precedence over the rules of the spec. Without any device rules, all
+// devices but the default ones are denied.
+func withDefaultDeviceRules(devices []specs.LinuxDeviceCgroup) []specs.LinuxDeviceCgroup {
+	if len(devices) == 0 {
+		devices = []specs.LinuxDeviceCgroup{denyAllDevices}
+	}
+
+	rules := make([]specs.LinuxDeviceCgroup, 0, len(devices)+len(defaultDeviceRules))
+	rules = append(rules, devices...)
+	return append(rules, defaultDeviceRules...)
+}
+
+// deviceFilter compiles devices into a BPF_PROG_TYPE_CGROUP_DEVICE program.
+// Rules behave like the devices controller of cgroup v1: the last rule that
+// matches an access decides whether it is allowed, and accesses that no rule
+// matches are denied.
+func deviceFilter(devices []specs.LinuxDeviceCgroup) (asm.Instructions, error) {
+	// struct bpf_cgroup_dev_ctx {
+	//	__u32 access_type; /* (access << 16) | type */
+	//	__u32 major;
+	//	__u32 minor;
+	// };
+	insts := asm.Instructions{
+		// R2 <- type
+		asm.LoadMem(asm.R2, asm.R1, 0, asm.Half),
+		// R3 <- access
+		asm.LoadMem(asm.R3, asm.R1, 0, asm.Word),
+		asm.RSh.Imm32(asm.R3, 16),
+		// R4 <- major
+		asm.LoadMem(asm.R4, asm.R1, 4, asm.Word),
+		// R5 <- minor
+		asm.LoadMem(asm.R5, asm.R1, 8, asm.Word),
+	}
+
+	// the rules are checked from the last one, so that the first match wins
+	block := 0
+	for i := len(devices) - 1; i >= 0; i-- {
+		rule, wildcard, err := deviceRule(devices[i], fmt.Sprintf("rule-%d", block+1))
+		if err != nil {
+			return nil, err
+		}
+
+		rule[0] = rule[0].Sym(fmt.Sprintf("rule-%d", block))
+		insts = append(insts, rule...)
+		block++
+
+		// the rules before a wildcard rule can never match
+		if wildcard {
+			return insts, nil
+		}
+	}
+
+	// deny by default
+	return append(insts,
+		asm.Mov.Imm32(asm.R0, 0).Sym(fmt.Sprintf("rule-%d", block)),
+		asm.Return(),
+	), nil
+}
+
+// deviceRule returns the instructions of a single rule, which jump to next when
+// the access does not match the rule. wildcard reports whether the rule matches
+// every access.
+func deviceRule(dev specs.LinuxDeviceCgroup, next string) (insts asm.Instructions, wildcard bool, err error) {
+	switch dev.Type {
+	case "c":
+		insts = append(insts, asm.JNE.Imm(asm.R2, unix.BPF_DEVCG_DEV_CHAR, next))
+	case "b":
+		insts = append(insts, asm.JNE.Imm(asm.R2, unix.BPF_DEVCG_DEV_BLOCK, next))
+	case "a", "":
+	default:
+		return nil, false, fmt.Errorf("invalid device type %q", dev.Type)
+	}
+
+	var access int32
+	for _, r := range dev.Access {
+		switch r {
+		case 'r':
+			access |= unix.BPF_DEVCG_ACC_READ
+		case 'w':
+			access |= unix.BPF_DEVCG_ACC_WRITE
+		case 'm':
+			access |= unix.BPF_DEVCG_ACC_MKNOD
+		default:
+			return nil, false, fmt.Errorf("invalid device access %q", dev.Access)
+		}
+	}
+
+	// an empty access is the same as "rwm". The rule only matches when it
+	// covers every access requested, e.g. an "r" rule does not match an open
+	// for reading and writing.
+	if access != 0 && access != unix.BPF_DEVCG_ACC_READ|unix.BPF_DEVCG_ACC_WRITE|unix.BPF_DEVCG_ACC_MKNOD {
+		insts = append(insts,
+			// R1 is no longer needed, so it is used as a temporary
+			asm.Mov.Reg32(asm.R1, asm.R3),
+			asm.And.Imm32(asm.R1, access),
+			asm.JNE.Reg(asm.R1, asm.R3, next),
+		)
+	}
+
+	// -1 or no number at all matches every major and minor
+	for _, n := range []struct {
+		reg   asm.Register
+		value *int64
+	}{{asm.R4, dev.Major}, {asm.R5, dev.Minor}} {
+		if n.value == nil || *n.value < 0 {
+			continue
+		}
+		if *n.value > math.MaxUint32 {
+			return nil, false, fmt.Errorf("invalid device number %d", *n.value)
+		}
+		insts = append(insts, asm.JNE.Imm(n.reg, int32(*n.value), next))
+	}
+
+	wildcard = len(insts) == 0
+
+	var allow int32
+	if dev.Allow {
+		allow = 1
+	}
+	insts = append(insts,
+		asm.Mov.Imm32(asm.R0, allow),
+		asm.Return(),
+	)
+
+	return insts, wildcard, nil
+}
+
+// setDevices attaches the device filter of devices and defaultDeviceRules to
+// the cgroup v2 at path. A device filter that was attached before is replaced
+// atomically, so that there is no window in which neither of the filters is in
+// force.
+func setDevices(path string, devices []specs.LinuxDeviceCgroup) error {
+	insts, err := deviceFilter(withDefaultDeviceRules(devices))
+	if err != nil {
+		return err
+	}
+
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type:         ebpf.CGroupDevice,
+		Instructions: insts,
+		License:      deviceFilterLicense,
+	})
+	if err != nil {
+		return fmt.Errorf("failed load device filter: %w", err)
+	}
+	defer prog.Close()
+
+	dirFd, err := unix.Open(path, unix.O_DIRECTORY|unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	defer unix.Close(dirFd)
+
+	old, err := attachedDeviceFilters(dirFd)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, p := range old {
+			p.Close()
+		}
+	}()
+
+	attach := link.RawAttachProgramOptions{
+		Target:  dirFd,
+		Program: prog,
+		Attach:  ebpf.AttachCGroupDevice,
+		Flags:   unix.BPF_F_ALLOW_MULTI,
+	}
+
+	if len(old) == 1 {
+		attach.Replace = old[0]
+		attach.Flags |= unix.BPF_F_REPLACE
+		if err := link.RawAttachProgram(attach); err == nil {
+			return nil
+		}
+
+		// BPF_F_REPLACE is not available before Linux 5.6
+		attach.Replace = nil
+		attach.Flags = unix.BPF_F_ALLOW_MULTI
+	}
+
+	// attach the new filter before detaching the old ones, since two filters
+	// are more restrictive than each of them
+	if err := link.RawAttachProgram(attach); err != nil {
+		return fmt.Errorf("failed attach device filter to %s: %w", path, err)
+	}
+
+	for _, p := range old {
+		err := link.RawDetachProgram(link.RawDetachProgramOptions{
+			Target:  dirFd,
+			Program: p,
+			Attach:  ebpf.AttachCGroupDevice,
+		})
+		if err != nil {
+			return fmt.Errorf("failed detach device filter from %s: %w", path, err)
+		}
+	}
+
+	return nil
+}
+
+// bpfProgQueryAttr is the BPF_PROG_QUERY part of union bpf_attr.
+type bpfProgQueryAttr struct {
+	targetFd    uint32
+	attachType  uint32
+	queryFlags  uint32
+	attachFlags uint32
+	progIds     uint64
+	progCnt     uint32
+	_           uint32
+}
+
+// attachedDeviceFilters returns the device filters attached to the cgroup dirFd.
+func attachedDeviceFilters(dirFd int) ([]*ebpf.Program, error) {
+	ids := make([]uint32, 64)
+	for {
+		attr := bpfProgQueryAttr{
+			targetFd:   uint32(dirFd),
+			attachType: uint32(ebpf.AttachCGroupDevice),
+			progIds:    uint64(uintptr(unsafe.Pointer(&ids[0]))),
+			progCnt:    uint32(len(ids)),
+		}
+
+		_, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_QUERY, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+		if errno == unix.ENOSPC {
+			ids = make([]uint32, attr.progCnt)
+			continue
+		}
+		if errno != 0 {
+			return nil, fmt.Errorf("failed query device filters: %w", errno)
+		}
+
+		progs := make([]*ebpf.Program, 0, attr.progCnt)
+		for _, id := range ids[:attr.progCnt] {
+			p, err := ebpf.NewProgramFromID(ebpf.ProgramID(id))
+			if err != nil {
+				for _, p := range progs {
+					p.Close()
+				}
+				return nil, fmt.Errorf("failed get device filter %d: %w", id, err)
+			}
+			progs = append(progs, p)
+		}
+
+		return progs, nil
+	}
+}
@@ -0,0 +1,348 @@
+package cgroups
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+type deviceAccess struct {
+	devType uint32
+	access  uint32
+	major   uint32
+	minor   uint32
+}
+
+// emulateDeviceFilter runs the device filter on access, supporting only the
+// instructions deviceFilter emits, and returns the value of R0.
+func emulateDeviceFilter(t *testing.T, insts asm.Instructions, access deviceAccess) uint64 {
+	t.Helper()
+
+	ctx := make([]byte, 12)
+	binary.LittleEndian.PutUint32(ctx[0:], access.access<<16|access.devType)
+	binary.LittleEndian.PutUint32(ctx[4:], access.major)
+	binary.LittleEndian.PutUint32(ctx[8:], access.minor)
+
+	symbols := map[string]int{}
+	for i, ins := range insts {
+		if ins.Symbol != "" {
+			symbols[ins.Symbol] = i
+		}
+	}
+
+	var regs [asm.R10 + 1]uint64
+	for pc := 0; pc < len(insts); pc++ {
+		ins := insts[pc]
+		op := ins.OpCode
+
+		switch op.Class() {
+		case asm.LdXClass:
+			require.Equal(t, asm.R1, ins.Src)
+			switch op.Size() {
+			case asm.Half:
+				regs[ins.Dst] = uint64(binary.LittleEndian.Uint16(ctx[ins.Offset:]))
+			case asm.Word:
+				regs[ins.Dst] = uint64(binary.LittleEndian.Uint32(ctx[ins.Offset:]))
+			default:
+				t.Fatalf("unsupported load: %v", ins)
+			}
+		case asm.ALUClass:
+			src := uint64(ins.Constant)
+			if op.Source() == asm.RegSource {
+				src = regs[ins.Src]
+			}
+
+			switch op.ALUOp() {
+			case asm.Mov:
+				regs[ins.Dst] = src
+			case asm.And:
+				regs[ins.Dst] &= src
+			case asm.RSh:
+				regs[ins.Dst] >>= src
+			default:
+				t.Fatalf("unsupported alu: %v", ins)
+			}
+			regs[ins.Dst] = uint64(uint32(regs[ins.Dst]))
+		case asm.JumpClass:
+			src := uint64(ins.Constant)
+			if op.Source() == asm.RegSource {
+				src = regs[ins.Src]
+			}
+
+			var taken bool
+			switch op.JumpOp() {
+			case asm.Exit:
+				return regs[asm.R0]
+			case asm.JEq:
+				taken = regs[ins.Dst] == src
+			case asm.JNE:
+				taken = regs[ins.Dst] != src
+			default:
+				t.Fatalf("unsupported jump: %v", ins)
+			}
+
+			if taken {
+				target, ok := symbols[ins.Reference]
+				require.True(t, ok, "undefined symbol %s", ins.Reference)
+				require.Greater(t, target, pc, "backward jump")
+				pc = target - 1
+			}
+		default:
+			t.Fatalf("unsupported instruction: %v", ins)
+		}
+	}
+
+	t.Fatal("the program did not exit")
+	return 0
+}
+
+func TestDeviceFilter(t *testing.T) {
+	const (
+		char  = unix.BPF_DEVCG_DEV_CHAR
+		block = unix.BPF_DEVCG_DEV_BLOCK
+		read  = unix.BPF_DEVCG_ACC_READ
+		write = unix.BPF_DEVCG_ACC_WRITE
+		mknod = unix.BPF_DEVCG_ACC_MKNOD
+	)
+
+	// the default rules of a container: deny everything, then allow some devices
+	defaultRules := []specs.LinuxDeviceCgroup{
+		{Allow: false, Access: "rwm"},
+		{Allow: true, Type: "c", Major: int64Ptr(1), Minor: int64Ptr(3), Access: "rwm"},
+		{Allow: true, Type: "c", Major: int64Ptr(1), Minor: int64Ptr(5), Access: "rw"},
+		{Allow: true, Type: "c", Major: int64Ptr(136), Minor: int64Ptr(-1), Access: "rwm"},
+		{Allow: true, Type: "b", Major: int64Ptr(8), Access: "m"},
+	}
+
+	tests := []struct {
+		name    string
+		devices []specs.LinuxDeviceCgroup
+		access  deviceAccess
+		want    uint64
+	}{
+		{
+			name:    "allowed device",
+			devices: defaultRules,
+			access:  deviceAccess{char, read | write, 1, 3},
+			want:    1,
+		},
+		{
+			name:    "allowed access",
+			devices: defaultRules,
+			access:  deviceAccess{char, write, 1, 5},
+			want:    1,
+		},
+		{
+			name:    "denied access",
+			devices: defaultRules,
+			access:  deviceAccess{char, mknod, 1, 5},
+			want:    0,
+		},
+		{
+			name:    "any minor",
+			devices: defaultRules,
+			access:  deviceAccess{char, read, 136, 42},
+			want:    1,
+		},
+		{
+			name:    "any minor without a minor",
+			devices: defaultRules,
+			access:  deviceAccess{block, mknod, 8, 1},
+			want:    1,
+		},
+		{
+			name:    "type mismatch",
+			devices: defaultRules,
+			access:  deviceAccess{block, read, 1, 3},
+			want:    0,
+		},
+		{
+			name:    "unknown device",
+			devices: defaultRules,
+			access:  deviceAccess{char, read, 10, 200},
+			want:    0,
+		},
+		{
+			name:    "denied by default",
+			devices: []specs.LinuxDeviceCgroup{{Allow: false, Type: "c", Major: int64Ptr(1), Minor: int64Ptr(3)}},
+			access:  deviceAccess{char, read, 10, 200},
+			want:    0,
+		},
+		{
+			name: "the last rule wins",
+			devices: []specs.LinuxDeviceCgroup{
+				{Allow: true, Type: "a"},
+				{Allow: false, Type: "c", Major: int64Ptr(10), Minor: int64Ptr(200), Access: "w"},
+			},
+			access: deviceAccess{char, write, 10, 200},
+			want:   0,
+		},
+		{
+			name: "rules after the denied access are still checked",
+			devices: []specs.LinuxDeviceCgroup{
+				{Allow: true, Type: "a"},
+				{Allow: false, Type: "c", Major: int64Ptr(10), Minor: int64Ptr(200), Access: "w"},
+			},
+			access: deviceAccess{char, read, 10, 200},
+			want:   1,
+		},
+		{
+			name:    "allowed access does not cover the request",
+			devices: []specs.LinuxDeviceCgroup{{Allow: true, Type: "c", Major: int64Ptr(1), Minor: int64Ptr(3), Access: "r"}},
+			access:  deviceAccess{char, read | write, 1, 3},
+			want:    0,
+		},
+		{
+			name: "wildcard after a rule",
+			devices: []specs.LinuxDeviceCgroup{
+				{Allow: true, Type: "c", Major: int64Ptr(1), Minor: int64Ptr(3), Access: "rwm"},
+				{Allow: false, Access: "rwm"},
+			},
+			access: deviceAccess{char, read, 1, 3},
+			want:   0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			insts, err := deviceFilter(tt.devices)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, emulateDeviceFilter(t, insts, tt.access))
+		})
+	}
+}
+
+func TestDeviceFilter_DefaultRules(t *testing.T) {
+	const (
+		char  = unix.BPF_DEVCG_DEV_CHAR
+		read  = unix.BPF_DEVCG_ACC_READ
+		write = unix.BPF_DEVCG_ACC_WRITE
+	)
+
+	specDevices := map[string][]specs.LinuxDeviceCgroup{
+		// the device rules of the default spec of runc
+		"deny all": {{Allow: false, Access: "rwm"}},
+		"no rules": nil,
+	}
+
+	tests := []struct {
+		name   string
+		access deviceAccess
+		want   uint64
+	}{
+		{name: "null", access: deviceAccess{char, read | write, 1, 3}, want: 1},
+		{name: "urandom", access: deviceAccess{char, read, 1, 9}, want: 1},
+		{name: "tty", access: deviceAccess{char, read | write, 5, 0}, want: 1},
+		{name: "ptmx", access: deviceAccess{char, read | write, 5, 2}, want: 1},
+		{name: "pts", access: deviceAccess{char, read | write, 136, 3}, want: 1},
+		{name: "other devices", access: deviceAccess{char, read, 10, 200}, want: 0},
+	}
+
+	for name, devices := range specDevices {
+		insts, err := deviceFilter(withDefaultDeviceRules(devices))
+		require.NoError(t, err)
+
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				assert.Equal(t, tt.want, emulateDeviceFilter(t, insts, tt.access))
+			})
+		}
+	}
+}
+
+func TestWithDefaultDeviceRules(t *testing.T) {
+	// without rules, everything but the default devices is denied
+	rules := withDefaultDeviceRules(nil)
+	assert.Equal(t, denyAllDevices, rules[0])
+	assert.Equal(t, defaultDeviceRules, rules[1:])
+
+	deny := specs.LinuxDeviceCgroup{Allow: false, Access: "rwm"}
+	rules = withDefaultDeviceRules([]specs.LinuxDeviceCgroup{deny})
+	assert.Equal(t, deny, rules[0])
+	assert.Equal(t, defaultDeviceRules, rules[1:])
+}
+
+func TestDeviceFilter_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		device specs.LinuxDeviceCgroup
+	}{
+		{name: "type", device: specs.LinuxDeviceCgroup{Type: "x"}},
+		{name: "access", device: specs.LinuxDeviceCgroup{Type: "c", Access: "rx"}},
+		{name: "major", device: specs.LinuxDeviceCgroup{Type: "c", Major: int64Ptr(1 << 32)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := deviceFilter([]specs.LinuxDeviceCgroup{tt.device})
+			assert.Error(t, err)
+		})
+	}
+}
+
+// unifiedMountpointForTest returns a cgroup v2 mount point of the host.
+func unifiedMountpointForTest(t *testing.T) string {
+	t.Helper()
+
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+
+	for _, path := range []string{unifiedMountpoint, filepath.Join(unifiedMountpoint, "unified")} {
+		var s unix.Statfs_t
+		if err := unix.Statfs(path, &s); err == nil && s.Type == unix.CGROUP2_SUPER_MAGIC {
+			return path
+		}
+	}
+
+	t.Skip("cgroup v2 is not mounted")
+	return ""
+}
+
+func TestSetDevices_Replace(t *testing.T) {
+	path, err := os.MkdirTemp(unifiedMountpointForTest(t), "noic-test-")
+	require.NoError(t, err)
+	defer os.Remove(path)
+
+	deny := []specs.LinuxDeviceCgroup{{Allow: false, Access: "rwm"}}
+	allow := []specs.LinuxDeviceCgroup{{Allow: true, Access: "rwm"}}
+
+	if err := setDevices(path, deny); err != nil {
+		t.Skipf("eBPF device filters are not supported: %s", err)
+	}
+
+	progIDs := func() []ebpf.ProgramID {
+		dirFd, err := unix.Open(path, unix.O_DIRECTORY|unix.O_RDONLY|unix.O_CLOEXEC, 0)
+		require.NoError(t, err)
+		defer unix.Close(dirFd)
+
+		progs, err := attachedDeviceFilters(dirFd)
+		require.NoError(t, err)
+
+		var ids []ebpf.ProgramID
+		for _, p := range progs {
+			id, err := p.ID()
+			require.NoError(t, err)
+			ids = append(ids, id)
+			p.Close()
+		}
+		return ids
+	}
+
+	before := progIDs()
+	require.Len(t, before, 1)
+
+	require.NoError(t, setDevices(path, allow))
+
+	after := progIDs()
+	require.Len(t, after, 1)
+	assert.NotEqual(t, before[0], after[0])
+}
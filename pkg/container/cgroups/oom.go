@@ -0,0 +1,139 @@
+package cgroups
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	cgroupsv1 "github.com/containerd/cgroups"
+	"golang.org/x/sys/unix"
+)
+
+// WatchOOMKill blocks until the OOM killer kills a process of the cgroup or
+// the cgroup is removed, and reports whether a process was killed. It returns
+// false at once when the cgroup has no memory controller.
+func (m *Manager) WatchOOMKill() (bool, error) {
+	if m.v1 != nil {
+		return m.watchOOMKillV1()
+	}
+
+	return watchOOMKillV2(m.path)
+}
+
+// watchOOMKillV1 waits on the OOM event fd of the memory controller, which is
+// also signaled when the cgroup is removed.
+func (m *Manager) watchOOMKillV1() (bool, error) {
+	efd, err := m.v1.OOMEventFD()
+	if errors.Is(err, cgroupsv1.ErrMemoryNotSupported) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer unix.Close(int(efd))
+
+	buf := make([]byte, 8)
+	for {
+		killed, err := m.oomKilledV1()
+		if err != nil || killed {
+			return killed, err
+		}
+
+		if _, err := unix.Read(int(efd), buf); err != nil {
+			if errors.Is(err, unix.EINTR) {
+				continue
+			}
+			return false, fmt.Errorf("failed read oom event: %w", err)
+		}
+
+		if !Exists(m.state) {
+			return false, nil
+		}
+	}
+}
+
+func (m *Manager) oomKilledV1() (bool, error) {
+	metrics, err := m.v1.Stat(cgroupsv1.IgnoreNotExist)
+	if err != nil {
+		return false, err
+	}
+
+	return metrics.MemoryOomControl != nil && metrics.MemoryOomControl.OomKill > 0, nil
+}
+
+// watchOOMKillV2 watches memory.events of the cgroup v2 at path with inotify.
+// The kernel notifies a change of the file, and the watch is removed together
+// with the cgroup.
+func watchOOMKillV2(path string) (bool, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return false, fmt.Errorf("failed init inotify: %w", err)
+	}
+	defer unix.Close(fd)
+
+	file := filepath.Join(path, "memory.events")
+	if _, err := unix.InotifyAddWatch(fd, file, unix.IN_MODIFY); err != nil {
+		if errors.Is(err, unix.ENOENT) {
+			return false, nil
+		}
+		return false, &os.PathError{Op: "inotify_add_watch", Path: file, Err: err}
+	}
+
+	buf := make([]byte, unix.SizeofInotifyEvent+unix.PathMax+1)
+	for {
+		oomKill, err := readOOMKill(file)
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, unix.ENODEV) {
+			return false, nil
+		}
+		if err != nil || oomKill > 0 {
+			return oomKill > 0, err
+		}
+
+		n, err := unix.Read(fd, buf)
+		if err != nil {
+			if errors.Is(err, unix.EINTR) {
+				continue
+			}
+			return false, fmt.Errorf("failed read inotify event: %w", err)
+		}
+
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			// the watch is removed when the cgroup is
+			if event.Mask&unix.IN_IGNORED != 0 {
+				return false, nil
+			}
+			offset += unix.SizeofInotifyEvent + int(event.Len)
+		}
+	}
+}
+
+// readOOMKill returns the oom_kill count of a memory.events file.
+func readOOMKill(file string) (uint64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		key, value, ok := strings.Cut(s.Text(), " ")
+		if !ok || key != "oom_kill" {
+			continue
+		}
+
+		oomKill, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid oom_kill in %s: %w", file, err)
+		}
+		return oomKill, nil
+	}
+
+	return 0, s.Err()
+}
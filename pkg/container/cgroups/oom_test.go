@@ -0,0 +1,90 @@
+package cgroups
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadOOMKill(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    uint64
+		wantErr bool
+	}{
+		{name: "no kill", content: "low 0\nhigh 0\nmax 3\noom 1\noom_kill 0\n", want: 0},
+		{name: "kill", content: "low 0\nhigh 0\nmax 3\noom 1\noom_kill 2\noom_group_kill 1\n", want: 2},
+		{name: "missing", content: "low 0\n", want: 0},
+		{name: "invalid", content: "oom_kill x\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "memory.events")
+			require.NoError(t, os.WriteFile(file, []byte(tt.content), 0o644))
+
+			got, err := readOOMKill(file)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestWatchOOMKillV2(t *testing.T) {
+	watch := func(t *testing.T, path string) <-chan bool {
+		done := make(chan bool, 1)
+		go func() {
+			killed, err := watchOOMKillV2(path)
+			assert.NoError(t, err)
+			done <- killed
+		}()
+		return done
+	}
+
+	wait := func(t *testing.T, done <-chan bool) bool {
+		select {
+		case killed := <-done:
+			return killed
+		case <-time.After(5 * time.Second):
+			t.Fatal("watchOOMKillV2 did not return")
+			return false
+		}
+	}
+
+	t.Run("kill", func(t *testing.T) {
+		path := t.TempDir()
+		file := filepath.Join(path, "memory.events")
+		require.NoError(t, os.WriteFile(file, []byte("oom 0\noom_kill 0\n"), 0o644))
+
+		done := watch(t, path)
+		time.Sleep(100 * time.Millisecond)
+		require.NoError(t, os.WriteFile(file, []byte("oom 1\noom_kill 1\n"), 0o644))
+
+		assert.True(t, wait(t, done))
+	})
+
+	t.Run("removed", func(t *testing.T) {
+		path := t.TempDir()
+		file := filepath.Join(path, "memory.events")
+		require.NoError(t, os.WriteFile(file, []byte("oom 0\noom_kill 0\n"), 0o644))
+
+		done := watch(t, path)
+		time.Sleep(100 * time.Millisecond)
+		require.NoError(t, os.Remove(file))
+
+		assert.False(t, wait(t, done))
+	})
+
+	t.Run("no memory controller", func(t *testing.T) {
+		assert.False(t, wait(t, watch(t, t.TempDir())))
+	})
+}
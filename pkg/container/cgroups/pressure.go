@@ -0,0 +1,178 @@
+package cgroups
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// AnnotationPressurePrefix followed by cpu, memory or io sets a PSI
+	// trigger on the resource, in the format "<some|full> <threshold> <window>"
+	// with the stall threshold and the window in microseconds.
+	AnnotationPressurePrefix = "org.noic.psi."
+
+	// AnnotationPressureHook is the path of an executable that is run with
+	// the event on the standard input when a PSI trigger fires.
+	AnnotationPressureHook = AnnotationPressurePrefix + "hook"
+
+	// the kernel accepts windows between 500ms and 10s, or multiples of 2s
+	// without CAP_SYS_RESOURCE
+	minPressureWindow = 500000
+	maxPressureWindow = 10000000
+
+	// pressurePollTimeout is how often WatchPressure checks for stop, in
+	// milliseconds
+	pressurePollTimeout = 500
+)
+
+var pressureResources = map[string]bool{"cpu": true, "memory": true, "io": true}
+
+// PressureTrigger fires when the tasks of Resource stall for Threshold
+// microseconds within Window microseconds.
+type PressureTrigger struct {
+	Resource  string `json:"resource"`
+	Stall     string `json:"stall"`
+	Threshold uint64 `json:"threshold"`
+	Window    uint64 `json:"window"`
+}
+
+// String returns the trigger in the format written to <resource>.pressure.
+func (t PressureTrigger) String() string {
+	return fmt.Sprintf("%s %d %d", t.Stall, t.Threshold, t.Window)
+}
+
+// PressureTriggersFromAnnotations returns the PSI triggers set by annotations,
+// sorted by resource.
+func PressureTriggersFromAnnotations(annotations map[string]string) ([]PressureTrigger, error) {
+	var triggers []PressureTrigger
+	for key, value := range annotations {
+		resource := strings.TrimPrefix(key, AnnotationPressurePrefix)
+		if resource == key || key == AnnotationPressureHook {
+			continue
+		}
+		if !pressureResources[resource] {
+			return nil, fmt.Errorf("invalid annotation %s: unknown resource %q", key, resource)
+		}
+
+		trigger, err := parsePressureTrigger(resource, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid annotation %s: %w", key, err)
+		}
+		triggers = append(triggers, trigger)
+	}
+
+	sort.Slice(triggers, func(i, j int) bool {
+		return triggers[i].Resource < triggers[j].Resource
+	})
+
+	return triggers, nil
+}
+
+func parsePressureTrigger(resource, s string) (PressureTrigger, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 3 {
+		return PressureTrigger{}, fmt.Errorf("invalid trigger %q: expect the format \"<some|full> <threshold> <window>\"", s)
+	}
+
+	trigger := PressureTrigger{Resource: resource, Stall: fields[0]}
+	if trigger.Stall != "some" && trigger.Stall != "full" {
+		return PressureTrigger{}, fmt.Errorf("invalid trigger %q: stall must be some or full", s)
+	}
+
+	var err error
+	if trigger.Threshold, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+		return PressureTrigger{}, fmt.Errorf("invalid trigger threshold %q: %w", fields[1], err)
+	}
+	if trigger.Window, err = strconv.ParseUint(fields[2], 10, 64); err != nil {
+		return PressureTrigger{}, fmt.Errorf("invalid trigger window %q: %w", fields[2], err)
+	}
+
+	if trigger.Window < minPressureWindow || trigger.Window > maxPressureWindow {
+		return PressureTrigger{}, fmt.Errorf("invalid trigger window %d: must be between %d and %d", trigger.Window, minPressureWindow, maxPressureWindow)
+	}
+	if trigger.Threshold == 0 || trigger.Threshold > trigger.Window {
+		return PressureTrigger{}, fmt.Errorf("invalid trigger threshold %d: must be between 1 and the window", trigger.Threshold)
+	}
+
+	return trigger, nil
+}
+
+// WatchPressure registers triggers on the cgroup and calls fn each time one of
+// them fires. It blocks until stop is closed or the cgroup is removed. PSI is
+// only available on cgroup v2.
+func (m *Manager) WatchPressure(triggers []PressureTrigger, stop <-chan struct{}, fn func(PressureTrigger)) error {
+	if m.v2 == nil {
+		return errors.New("pressure stall information requires cgroup v2")
+	}
+
+	fds := make([]unix.PollFd, 0, len(triggers))
+	defer func() {
+		for _, fd := range fds {
+			unix.Close(int(fd.Fd))
+		}
+	}()
+
+	for _, t := range triggers {
+		fd, err := openPressureTrigger(m.path, t)
+		if err != nil {
+			return err
+		}
+		fds = append(fds, unix.PollFd{Fd: int32(fd), Events: unix.POLLPRI})
+	}
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+
+		n, err := unix.Poll(fds, pressurePollTimeout)
+		if errors.Is(err, unix.EINTR) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed poll pressure triggers: %w", err)
+		}
+		if n == 0 {
+			continue
+		}
+
+		for i, fd := range fds {
+			// the trigger is removed with the cgroup
+			if fd.Revents&unix.POLLERR != 0 {
+				return nil
+			}
+			if fd.Revents&unix.POLLPRI != 0 {
+				fn(triggers[i])
+			}
+		}
+	}
+}
+
+// openPressureTrigger registers t on the cgroup v2 at path. The trigger lives
+// as long as the returned file descriptor is open.
+func openPressureTrigger(path string, t PressureTrigger) (int, error) {
+	file := filepath.Join(path, t.Resource+".pressure")
+	fd, err := unix.Open(file, unix.O_RDWR|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, &os.PathError{Op: "open", Path: file, Err: err}
+	}
+
+	if _, err := unix.Write(fd, []byte(t.String()+"\x00")); err != nil {
+		unix.Close(fd)
+		if errors.Is(err, unix.EINVAL) && t.Window%2000000 != 0 {
+			return -1, fmt.Errorf("failed set pressure trigger %q on %s: %w (the window must be a multiple of 2s without CAP_SYS_RESOURCE)", t, file, err)
+		}
+		return -1, fmt.Errorf("failed set pressure trigger %q on %s: %w", t, file, err)
+	}
+
+	return fd, nil
+}
@@ -0,0 +1,148 @@
+package cgroups
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cgroupsv2 "github.com/containerd/cgroups/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPressureTriggersFromAnnotations(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        []PressureTrigger
+		wantErr     bool
+	}{
+		{
+			name:        "no trigger",
+			annotations: map[string]string{"org.noic.landlock.ro": "/usr"},
+		},
+		{
+			name: "triggers",
+			annotations: map[string]string{
+				"org.noic.psi.memory": "some 150000 1000000",
+				"org.noic.psi.cpu":    " full  500000 500000 ",
+				"org.noic.psi.hook":   "/usr/local/bin/alert",
+			},
+			want: []PressureTrigger{
+				{Resource: "cpu", Stall: "full", Threshold: 500000, Window: 500000},
+				{Resource: "memory", Stall: "some", Threshold: 150000, Window: 1000000},
+			},
+		},
+		{
+			name:        "unknown resource",
+			annotations: map[string]string{"org.noic.psi.irq": "some 150000 1000000"},
+			wantErr:     true,
+		},
+		{
+			name:        "unknown stall",
+			annotations: map[string]string{"org.noic.psi.io": "any 150000 1000000"},
+			wantErr:     true,
+		},
+		{
+			name:        "missing window",
+			annotations: map[string]string{"org.noic.psi.io": "some 150000"},
+			wantErr:     true,
+		},
+		{
+			name:        "invalid threshold",
+			annotations: map[string]string{"org.noic.psi.io": "some -1 1000000"},
+			wantErr:     true,
+		},
+		{
+			name:        "threshold above the window",
+			annotations: map[string]string{"org.noic.psi.io": "some 2000000 1000000"},
+			wantErr:     true,
+		},
+		{
+			name:        "window too short",
+			annotations: map[string]string{"org.noic.psi.io": "some 1000 100000"},
+			wantErr:     true,
+		},
+		{
+			name:        "window too long",
+			annotations: map[string]string{"org.noic.psi.io": "some 1000 20000000"},
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PressureTriggersFromAnnotations(tt.annotations)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestPressureTrigger_String(t *testing.T) {
+	trigger := PressureTrigger{Resource: "memory", Stall: "some", Threshold: 150000, Window: 1000000}
+	assert.Equal(t, "some 150000 1000000", trigger.String())
+}
+
+func TestWatchPressure(t *testing.T) {
+	mountpoint := unifiedMountpointForTest(t)
+	if _, err := os.Stat(filepath.Join(mountpoint, "memory.pressure")); err != nil {
+		t.Skip("pressure stall information is not enabled")
+	}
+
+	path, err := os.MkdirTemp(mountpoint, "noic-test-")
+	require.NoError(t, err)
+	defer os.Remove(path)
+
+	v2, err := cgroupsv2.LoadManager(mountpoint, "/"+filepath.Base(path))
+	require.NoError(t, err)
+	m := &Manager{v2: v2, path: path}
+
+	// a window of 2s is accepted without CAP_SYS_RESOURCE as well
+	triggers := []PressureTrigger{{Resource: "memory", Stall: "some", Threshold: 150000, Window: 2000000}}
+
+	t.Run("stop", func(t *testing.T) {
+		stop := make(chan struct{})
+		done := make(chan error, 1)
+		go func() {
+			done <- m.WatchPressure(triggers, stop, func(PressureTrigger) {})
+		}()
+
+		close(stop)
+		select {
+		case err := <-done:
+			assert.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("WatchPressure did not return after stop")
+		}
+	})
+
+	t.Run("cgroup removed", func(t *testing.T) {
+		done := make(chan error, 1)
+		go func() {
+			done <- m.WatchPressure(triggers, make(chan struct{}), func(PressureTrigger) {})
+		}()
+
+		// give the watch time to register the trigger
+		time.Sleep(100 * time.Millisecond)
+		require.NoError(t, os.Remove(path))
+
+		select {
+		case err := <-done:
+			assert.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("WatchPressure did not return after the cgroup was removed")
+		}
+	})
+}
+
+func TestWatchPressure_V1(t *testing.T) {
+	m := &Manager{}
+	err := m.WatchPressure(nil, make(chan struct{}), func(PressureTrigger) {})
+	assert.Error(t, err)
+}
@@ -0,0 +1,349 @@
+package cgroups
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+)
+
+// v2Value is the content written to a file of a cgroup v2.
+type v2Value struct {
+	file  string
+	value string
+	// optional files are skipped when the kernel does not provide them
+	optional bool
+}
+
+// toV2Values translates resources into the files of a cgroup v2, following
+// the conversion of the runtime-spec. Devices and unified resources are not
+// included. Resources that have no cgroup v2 equivalent are ignored with a
+// warning, except for the realtime CPU resources, which are an error.
+func toV2Values(r *specs.LinuxResources) ([]v2Value, error) {
+	if r == nil {
+		return nil, nil
+	}
+
+	var values []v2Value
+	for _, convert := range []func(*specs.LinuxResources) ([]v2Value, error){
+		cpuValues,
+		memoryValues,
+		pidsValues,
+		ioValues,
+		hugetlbValues,
+		rdmaValues,
+	} {
+		v, err := convert(r)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v...)
+	}
+
+	if r.Network != nil && (r.Network.ClassID != nil || len(r.Network.Priorities) > 0) {
+		logrus.Warn("network resources are not supported on cgroup v2, ignoring")
+	}
+
+	return values, nil
+}
+
+// convertCPUSharesToWeight converts cpu.shares in [2, 262144] to cpu.weight
+// in [1, 10000].
+func convertCPUSharesToWeight(shares uint64) uint64 {
+	if shares < 2 {
+		shares = 2
+	}
+	if shares > 262144 {
+		shares = 262144
+	}
+
+	return 1 + ((shares-2)*9999)/262142
+}
+
+// convertBlkIOWeightToIOWeight converts blkio.weight in [10, 1000] to
+// io.weight in [1, 10000].
+func convertBlkIOWeightToIOWeight(weight uint16) uint64 {
+	w := uint64(weight)
+	if w < 10 {
+		w = 10
+	}
+	if w > 1000 {
+		w = 1000
+	}
+
+	return 1 + ((w-10)*9999)/990
+}
+
+// convertMemorySwapToSwapMax converts the memory+swap limit of cgroup v1 to
+// memory.swap.max, which limits the swap alone. ok is false when swap is left
+// as it is.
+func convertMemorySwapToSwapMax(memorySwap, memory int64) (value string, ok bool, err error) {
+	// swap is unlimited as well when only memory is unlimited, as on cgroup v1
+	if memory == -1 && memorySwap == 0 {
+		return "max", true, nil
+	}
+
+	switch {
+	case memorySwap == 0:
+		return "", false, nil
+	case memorySwap == -1:
+		return "max", true, nil
+	case memorySwap < 0:
+		return "", false, fmt.Errorf("invalid memory swap limit %d", memorySwap)
+	case memory <= 0:
+		return "", false, errors.New("memory swap limit requires a memory limit")
+	case memorySwap < memory:
+		return "", false, fmt.Errorf("memory swap limit %d must be greater than or equal to the memory limit %d", memorySwap, memory)
+	}
+
+	return strconv.FormatInt(memorySwap-memory, 10), true, nil
+}
+
+// formatLimit formats a limit where a negative value is unlimited.
+func formatLimit(limit int64) string {
+	if limit < 0 {
+		return "max"
+	}
+
+	return strconv.FormatInt(limit, 10)
+}
+
+func cpuValues(r *specs.LinuxResources) ([]v2Value, error) {
+	cpu := r.CPU
+	if cpu == nil {
+		return nil, nil
+	}
+
+	if (cpu.RealtimeRuntime != nil && *cpu.RealtimeRuntime != 0) || (cpu.RealtimePeriod != nil && *cpu.RealtimePeriod != 0) {
+		return nil, errors.New("realtime cpu resources are not supported on cgroup v2")
+	}
+
+	var values []v2Value
+	if cpu.Shares != nil && *cpu.Shares != 0 {
+		values = append(values, v2Value{file: "cpu.weight", value: strconv.FormatUint(convertCPUSharesToWeight(*cpu.Shares), 10)})
+	}
+
+	var quota int64
+	if cpu.Quota != nil {
+		quota = *cpu.Quota
+	}
+	var period uint64
+	if cpu.Period != nil {
+		period = *cpu.Period
+	}
+	if quota != 0 || period != 0 {
+		max := "max"
+		if quota > 0 {
+			max = strconv.FormatInt(quota, 10)
+		}
+		// the period is left as it is when not given
+		if period != 0 {
+			max += " " + strconv.FormatUint(period, 10)
+		}
+		values = append(values, v2Value{file: "cpu.max", value: max})
+	}
+
+	if cpu.Idle != nil {
+		values = append(values, v2Value{file: "cpu.idle", value: strconv.FormatInt(*cpu.Idle, 10)})
+	}
+
+	if cpu.Cpus != "" {
+		values = append(values, v2Value{file: "cpuset.cpus", value: cpu.Cpus})
+	}
+	if cpu.Mems != "" {
+		values = append(values, v2Value{file: "cpuset.mems", value: cpu.Mems})
+	}
+
+	return values, nil
+}
+
+func memoryValues(r *specs.LinuxResources) ([]v2Value, error) {
+	memory := r.Memory
+	if memory == nil {
+		return nil, nil
+	}
+
+	var limit, swap int64
+	if memory.Limit != nil {
+		limit = *memory.Limit
+	}
+	if memory.Swap != nil {
+		swap = *memory.Swap
+	}
+
+	var values []v2Value
+	if limit != 0 {
+		values = append(values, v2Value{file: "memory.max", value: formatLimit(limit)})
+	}
+
+	swapMax, ok, err := convertMemorySwapToSwapMax(swap, limit)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		values = append(values, v2Value{file: "memory.swap.max", value: swapMax})
+	}
+
+	if memory.Reservation != nil && *memory.Reservation != 0 {
+		values = append(values, v2Value{file: "memory.low", value: formatLimit(*memory.Reservation)})
+	}
+
+	if memory.Kernel != nil || memory.KernelTCP != nil {
+		logrus.Warn("kernel memory limits are not supported on cgroup v2, ignoring")
+	}
+	if memory.Swappiness != nil {
+		logrus.Warn("memory swappiness is not supported on cgroup v2, ignoring")
+	}
+	if memory.DisableOOMKiller != nil && *memory.DisableOOMKiller {
+		logrus.Warn("disabling the OOM killer is not supported on cgroup v2, ignoring")
+	}
+
+	return values, nil
+}
+
+func pidsValues(r *specs.LinuxResources) ([]v2Value, error) {
+	if r.Pids == nil {
+		return nil, nil
+	}
+
+	// 0 is taken as unlimited as well
+	limit := r.Pids.Limit
+	if limit == 0 {
+		limit = -1
+	}
+
+	return []v2Value{{file: "pids.max", value: formatLimit(limit)}}, nil
+}
+
+func ioValues(r *specs.LinuxResources) ([]v2Value, error) {
+	blkio := r.BlockIO
+	if blkio == nil {
+		return nil, nil
+	}
+
+	var values []v2Value
+	if blkio.Weight != nil && *blkio.Weight != 0 {
+		values = append(values, v2Value{file: "io.weight", value: "default " + strconv.FormatUint(convertBlkIOWeightToIOWeight(*blkio.Weight), 10)})
+	}
+
+	leafWeight := blkio.LeafWeight != nil
+	for _, d := range blkio.WeightDevice {
+		if d.Weight != nil {
+			values = append(values, v2Value{file: "io.weight", value: fmt.Sprintf("%d:%d %d", d.Major, d.Minor, convertBlkIOWeightToIOWeight(*d.Weight))})
+		}
+		leafWeight = leafWeight || d.LeafWeight != nil
+	}
+	if leafWeight {
+		logrus.Warn("blkio leaf weights are not supported on cgroup v2, ignoring")
+	}
+
+	for _, throttle := range []struct {
+		key     string
+		devices []specs.LinuxThrottleDevice
+	}{
+		{"rbps", blkio.ThrottleReadBpsDevice},
+		{"wbps", blkio.ThrottleWriteBpsDevice},
+		{"riops", blkio.ThrottleReadIOPSDevice},
+		{"wiops", blkio.ThrottleWriteIOPSDevice},
+	} {
+		for _, d := range throttle.devices {
+			// a rate of 0 removes the limit
+			rate := "max"
+			if d.Rate != 0 {
+				rate = strconv.FormatUint(d.Rate, 10)
+			}
+			values = append(values, v2Value{file: "io.max", value: fmt.Sprintf("%d:%d %s=%s", d.Major, d.Minor, throttle.key, rate)})
+		}
+	}
+
+	return values, nil
+}
+
+func hugetlbValues(r *specs.LinuxResources) ([]v2Value, error) {
+	var values []v2Value
+	for _, h := range r.HugepageLimits {
+		if h.Pagesize == "" || strings.ContainsAny(h.Pagesize, "/.") {
+			return nil, fmt.Errorf("invalid hugepage size %q", h.Pagesize)
+		}
+
+		limit := strconv.FormatUint(h.Limit, 10)
+		values = append(values,
+			v2Value{file: "hugetlb." + h.Pagesize + ".max", value: limit},
+			// reservations are limited as well where the kernel accounts them
+			v2Value{file: "hugetlb." + h.Pagesize + ".rsvd.max", value: limit, optional: true},
+		)
+	}
+
+	return values, nil
+}
+
+func rdmaValues(r *specs.LinuxResources) ([]v2Value, error) {
+	devices := make([]string, 0, len(r.Rdma))
+	for device := range r.Rdma {
+		devices = append(devices, device)
+	}
+	sort.Strings(devices)
+
+	var values []v2Value
+	for _, device := range devices {
+		limit := r.Rdma[device]
+		if limit.HcaHandles == nil && limit.HcaObjects == nil {
+			continue
+		}
+
+		handles, objects := "max", "max"
+		if limit.HcaHandles != nil {
+			handles = strconv.FormatUint(uint64(*limit.HcaHandles), 10)
+		}
+		if limit.HcaObjects != nil {
+			objects = strconv.FormatUint(uint64(*limit.HcaObjects), 10)
+		}
+		values = append(values, v2Value{file: "rdma.max", value: fmt.Sprintf("%s hca_handle=%s hca_object=%s", device, handles, objects)})
+	}
+
+	return values, nil
+}
+
+// v2Controllers returns the controllers the files of values belong to.
+func v2Controllers(values []v2Value) []string {
+	seen := map[string]bool{}
+	var controllers []string
+	for _, v := range values {
+		controller, _, _ := strings.Cut(v.file, ".")
+		if seen[controller] {
+			continue
+		}
+		seen[controller] = true
+		controllers = append(controllers, controller)
+	}
+
+	return controllers
+}
+
+// writeV2Values writes values to the cgroup v2 at path in order.
+func writeV2Values(path string, values []v2Value) error {
+	for _, v := range values {
+		// the files of a cgroup are never created by writing
+		f, err := os.OpenFile(filepath.Join(path, v.file), os.O_WRONLY|os.O_TRUNC, 0)
+		if errors.Is(err, os.ErrNotExist) && v.optional {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = f.WriteString(v.value)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed write %s to %s: %w", v.value, v.file, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,332 @@
+package cgroups
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func uint64Ptr(i uint64) *uint64 {
+	return &i
+}
+
+func uint32Ptr(i uint32) *uint32 {
+	return &i
+}
+
+func uint16Ptr(i uint16) *uint16 {
+	return &i
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func TestConvertCPUSharesToWeight(t *testing.T) {
+	tests := []struct {
+		shares uint64
+		want   uint64
+	}{
+		{shares: 2, want: 1},
+		{shares: 1024, want: 39},
+		{shares: 262144, want: 10000},
+		// out of range values are clamped
+		{shares: 1, want: 1},
+		{shares: 1 << 20, want: 10000},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, convertCPUSharesToWeight(tt.shares), "shares %d", tt.shares)
+	}
+}
+
+func TestConvertBlkIOWeightToIOWeight(t *testing.T) {
+	tests := []struct {
+		weight uint16
+		want   uint64
+	}{
+		{weight: 10, want: 1},
+		{weight: 500, want: 4950},
+		{weight: 1000, want: 10000},
+		{weight: 1, want: 1},
+		{weight: 2000, want: 10000},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, convertBlkIOWeightToIOWeight(tt.weight), "weight %d", tt.weight)
+	}
+}
+
+func TestConvertMemorySwapToSwapMax(t *testing.T) {
+	tests := []struct {
+		name    string
+		swap    int64
+		memory  int64
+		want    string
+		wantOk  bool
+		wantErr bool
+	}{
+		{name: "unset", swap: 0, memory: 0, wantOk: false},
+		{name: "unset with memory limit", swap: 0, memory: 1000, wantOk: false},
+		{name: "unlimited memory", swap: 0, memory: -1, want: "max", wantOk: true},
+		{name: "unlimited", swap: -1, memory: 1000, want: "max", wantOk: true},
+		{name: "unlimited both", swap: -1, memory: -1, want: "max", wantOk: true},
+		{name: "memory+swap", swap: 3000, memory: 1000, want: "2000", wantOk: true},
+		{name: "no swap", swap: 1000, memory: 1000, want: "0", wantOk: true},
+		{name: "less than memory", swap: 500, memory: 1000, wantErr: true},
+		{name: "without memory limit", swap: 1000, memory: 0, wantErr: true},
+		{name: "with unlimited memory", swap: 1000, memory: -1, wantErr: true},
+		{name: "invalid", swap: -2, memory: 1000, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok, err := convertMemorySwapToSwapMax(tt.swap, tt.memory)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestToV2Values(t *testing.T) {
+	tests := []struct {
+		name      string
+		resources *specs.LinuxResources
+		want      []v2Value
+		wantErr   bool
+	}{
+		{
+			name:      "nil",
+			resources: nil,
+			want:      nil,
+		},
+		{
+			name:      "empty",
+			resources: &specs.LinuxResources{},
+			want:      nil,
+		},
+		{
+			name: "cpu",
+			resources: &specs.LinuxResources{CPU: &specs.LinuxCPU{
+				Shares: uint64Ptr(1024),
+				Quota:  int64Ptr(50000),
+				Period: uint64Ptr(100000),
+				Idle:   int64Ptr(1),
+				Cpus:   "0-1",
+				Mems:   "0",
+			}},
+			want: []v2Value{
+				{file: "cpu.weight", value: "39"},
+				{file: "cpu.max", value: "50000 100000"},
+				{file: "cpu.idle", value: "1"},
+				{file: "cpuset.cpus", value: "0-1"},
+				{file: "cpuset.mems", value: "0"},
+			},
+		},
+		{
+			name:      "cpu quota without period",
+			resources: &specs.LinuxResources{CPU: &specs.LinuxCPU{Quota: int64Ptr(50000)}},
+			want:      []v2Value{{file: "cpu.max", value: "50000"}},
+		},
+		{
+			name:      "cpu period without quota",
+			resources: &specs.LinuxResources{CPU: &specs.LinuxCPU{Period: uint64Ptr(100000)}},
+			want:      []v2Value{{file: "cpu.max", value: "max 100000"}},
+		},
+		{
+			name:      "unlimited cpu quota",
+			resources: &specs.LinuxResources{CPU: &specs.LinuxCPU{Quota: int64Ptr(-1)}},
+			want:      []v2Value{{file: "cpu.max", value: "max"}},
+		},
+		{
+			name:      "zero cpu shares",
+			resources: &specs.LinuxResources{CPU: &specs.LinuxCPU{Shares: uint64Ptr(0)}},
+			want:      nil,
+		},
+		{
+			name:      "realtime cpu",
+			resources: &specs.LinuxResources{CPU: &specs.LinuxCPU{RealtimeRuntime: int64Ptr(1000)}},
+			wantErr:   true,
+		},
+		{
+			name: "memory",
+			resources: &specs.LinuxResources{Memory: &specs.LinuxMemory{
+				Limit:       int64Ptr(1 << 30),
+				Swap:        int64Ptr(2 << 30),
+				Reservation: int64Ptr(1 << 29),
+			}},
+			want: []v2Value{
+				{file: "memory.max", value: "1073741824"},
+				{file: "memory.swap.max", value: "1073741824"},
+				{file: "memory.low", value: "536870912"},
+			},
+		},
+		{
+			name:      "unlimited memory",
+			resources: &specs.LinuxResources{Memory: &specs.LinuxMemory{Limit: int64Ptr(-1)}},
+			want: []v2Value{
+				{file: "memory.max", value: "max"},
+				{file: "memory.swap.max", value: "max"},
+			},
+		},
+		{
+			name: "unsupported memory resources are ignored",
+			resources: &specs.LinuxResources{Memory: &specs.LinuxMemory{
+				Kernel:           int64Ptr(1 << 20),
+				KernelTCP:        int64Ptr(1 << 20),
+				Swappiness:       uint64Ptr(60),
+				DisableOOMKiller: boolPtr(true),
+				UseHierarchy:     boolPtr(true),
+			}},
+			want: nil,
+		},
+		{
+			name:      "swap without memory limit",
+			resources: &specs.LinuxResources{Memory: &specs.LinuxMemory{Swap: int64Ptr(1 << 30)}},
+			wantErr:   true,
+		},
+		{
+			name:      "pids",
+			resources: &specs.LinuxResources{Pids: &specs.LinuxPids{Limit: 100}},
+			want:      []v2Value{{file: "pids.max", value: "100"}},
+		},
+		{
+			name:      "unlimited pids",
+			resources: &specs.LinuxResources{Pids: &specs.LinuxPids{Limit: -1}},
+			want:      []v2Value{{file: "pids.max", value: "max"}},
+		},
+		{
+			name:      "zero pids",
+			resources: &specs.LinuxResources{Pids: &specs.LinuxPids{Limit: 0}},
+			want:      []v2Value{{file: "pids.max", value: "max"}},
+		},
+		{
+			name: "block io",
+			resources: &specs.LinuxResources{BlockIO: &specs.LinuxBlockIO{
+				Weight:     uint16Ptr(500),
+				LeafWeight: uint16Ptr(500),
+				WeightDevice: []specs.LinuxWeightDevice{
+					{LinuxBlockIODevice: specs.LinuxBlockIODevice{Major: 8, Minor: 0}, Weight: uint16Ptr(1000)},
+					{LinuxBlockIODevice: specs.LinuxBlockIODevice{Major: 8, Minor: 16}, LeafWeight: uint16Ptr(10)},
+				},
+				ThrottleReadBpsDevice:   []specs.LinuxThrottleDevice{{LinuxBlockIODevice: specs.LinuxBlockIODevice{Major: 8, Minor: 0}, Rate: 1 << 20}},
+				ThrottleWriteBpsDevice:  []specs.LinuxThrottleDevice{{LinuxBlockIODevice: specs.LinuxBlockIODevice{Major: 8, Minor: 0}, Rate: 2 << 20}},
+				ThrottleReadIOPSDevice:  []specs.LinuxThrottleDevice{{LinuxBlockIODevice: specs.LinuxBlockIODevice{Major: 8, Minor: 0}, Rate: 100}},
+				ThrottleWriteIOPSDevice: []specs.LinuxThrottleDevice{{LinuxBlockIODevice: specs.LinuxBlockIODevice{Major: 8, Minor: 0}, Rate: 0}},
+			}},
+			want: []v2Value{
+				{file: "io.weight", value: "default 4950"},
+				{file: "io.weight", value: "8:0 10000"},
+				{file: "io.max", value: "8:0 rbps=1048576"},
+				{file: "io.max", value: "8:0 wbps=2097152"},
+				{file: "io.max", value: "8:0 riops=100"},
+				{file: "io.max", value: "8:0 wiops=max"},
+			},
+		},
+		{
+			name: "hugepages",
+			resources: &specs.LinuxResources{HugepageLimits: []specs.LinuxHugepageLimit{
+				{Pagesize: "2MB", Limit: 1 << 30},
+				{Pagesize: "1GB", Limit: 0},
+			}},
+			want: []v2Value{
+				{file: "hugetlb.2MB.max", value: "1073741824"},
+				{file: "hugetlb.2MB.rsvd.max", value: "1073741824", optional: true},
+				{file: "hugetlb.1GB.max", value: "0"},
+				{file: "hugetlb.1GB.rsvd.max", value: "0", optional: true},
+			},
+		},
+		{
+			name:      "invalid hugepage size",
+			resources: &specs.LinuxResources{HugepageLimits: []specs.LinuxHugepageLimit{{Pagesize: "../2MB"}}},
+			wantErr:   true,
+		},
+		{
+			name: "rdma",
+			resources: &specs.LinuxResources{Rdma: map[string]specs.LinuxRdma{
+				"mlx5_1": {HcaHandles: uint32Ptr(3)},
+				"mlx4_0": {HcaHandles: uint32Ptr(2), HcaObjects: uint32Ptr(2000)},
+				"mlx6_0": {},
+			}},
+			want: []v2Value{
+				{file: "rdma.max", value: "mlx4_0 hca_handle=2 hca_object=2000"},
+				{file: "rdma.max", value: "mlx5_1 hca_handle=3 hca_object=max"},
+			},
+		},
+		{
+			name: "network is ignored",
+			resources: &specs.LinuxResources{Network: &specs.LinuxNetwork{
+				ClassID:    uint32Ptr(1),
+				Priorities: []specs.LinuxInterfacePriority{{Name: "eth0", Priority: 1}},
+			}},
+			want: nil,
+		},
+		{
+			name: "devices and unified are left out",
+			resources: &specs.LinuxResources{
+				Devices: []specs.LinuxDeviceCgroup{{Allow: false, Access: "rwm"}},
+				Unified: map[string]string{"memory.high": "1G"},
+			},
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := toV2Values(tt.resources)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestV2Controllers(t *testing.T) {
+	values := []v2Value{
+		{file: "cpu.weight"},
+		{file: "cpuset.cpus"},
+		{file: "memory.max"},
+		{file: "cpu.max"},
+		{file: "hugetlb.2MB.max"},
+	}
+
+	assert.Equal(t, []string{"cpu", "cpuset", "memory", "hugetlb"}, v2Controllers(values))
+}
+
+func TestWriteV2Values(t *testing.T) {
+	path := t.TempDir()
+	for _, file := range []string{"memory.max", "hugetlb.2MB.max"} {
+		require.NoError(t, os.WriteFile(filepath.Join(path, file), []byte("max\n"), 0o644))
+	}
+
+	values := []v2Value{
+		{file: "memory.max", value: "1024"},
+		{file: "hugetlb.2MB.max", value: "2048"},
+		{file: "hugetlb.2MB.rsvd.max", value: "2048", optional: true},
+	}
+	require.NoError(t, writeV2Values(path, values))
+
+	b, err := os.ReadFile(filepath.Join(path, "memory.max"))
+	require.NoError(t, err)
+	assert.Equal(t, "1024", string(b))
+
+	_, err = os.Stat(filepath.Join(path, "hugetlb.2MB.rsvd.max"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// a file that is not optional has to exist
+	assert.Error(t, writeV2Values(path, []v2Value{{file: "pids.max", value: "10"}}))
+}
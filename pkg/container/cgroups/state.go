@@ -0,0 +1,249 @@
+package cgroups
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	cgroupsv1 "github.com/containerd/cgroups"
+	cgroupsv2 "github.com/containerd/cgroups/v2"
+	systemdDbus "github.com/coreos/go-systemd/v22/dbus"
+	"github.com/godbus/dbus/v5"
+	"golang.org/x/sys/unix"
+)
+
+// State is the cgroup of a container as persisted in state.json, from which
+// the cgroup is found again after noic has exited.
+type State struct {
+	// Path is the path of the cgroup relative to the cgroup mount point
+	Path string `json:"path"`
+	// Slice and Unit are set when the cgroup is managed by systemd
+	Slice string `json:"slice,omitempty"`
+	Unit  string `json:"unit,omitempty"`
+}
+
+// State returns the persistable state of the cgroup.
+func (m *Manager) State() State {
+	return m.state
+}
+
+// Load returns the Manager of the cgroup of state.
+func Load(state State) (*Manager, error) {
+	if !IsVersion2() {
+		path := cgroupsv1.StaticPath(state.Path)
+		if state.Unit != "" {
+			path = cgroupsv1.Slice(state.Slice, state.Unit)
+		}
+
+		control, err := cgroupsv1.Load(v1Hierarchy(state.Unit != ""), path)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Manager{v1: control, state: state}, nil
+	}
+
+	m, err := cgroupsv2.LoadManager(unifiedMountpoint, state.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Manager{
+		v2:    m,
+		path:  filepath.Join(unifiedMountpoint, state.Path),
+		state: state,
+	}, nil
+}
+
+// Exists reports whether the cgroup of state still exists. It is gone when it
+// has been destroyed, or with the unit when systemd manages it.
+func Exists(state State) bool {
+	if !IsVersion2() {
+		_, err := Load(state)
+		return !errors.Is(err, cgroupsv1.ErrCgroupDeleted)
+	}
+
+	_, err := os.Stat(filepath.Join(unifiedMountpoint, state.Path))
+	return err == nil
+}
+
+// Destroy kills the processes left in the cgroup of state and removes the
+// cgroup, stopping the systemd unit when there is one. A cgroup that no longer
+// exists is not an error.
+func Destroy(state State) error {
+	m, err := Load(state)
+	if errors.Is(err, cgroupsv1.ErrCgroupDeleted) {
+		if state.Unit != "" {
+			return stopUnit(state.Unit)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if m.v1 != nil {
+		return m.destroyV1()
+	}
+
+	return m.destroyV2()
+}
+
+func (m *Manager) destroyV2() error {
+	err := killAll(func() ([]uint64, error) {
+		return m.v2.Procs(true)
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if m.state.Unit != "" {
+		if err := stopUnit(m.state.Unit); err != nil {
+			return err
+		}
+	}
+
+	// removing a cgroup that systemd has already removed is not an error
+	return m.v2.Delete()
+}
+
+func (m *Manager) destroyV1() error {
+	err := killAll(func() ([]uint64, error) {
+		var pids []uint64
+		for _, s := range m.v1.Subsystems() {
+			procs, err := m.v1.Processes(s.Name(), true)
+			if err != nil {
+				// the systemd controller has no processes of its own
+				continue
+			}
+			for _, p := range procs {
+				pids = append(pids, uint64(p.Pid))
+			}
+		}
+		return pids, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// the systemd controller is not loaded with the cgroup, so the unit is
+	// stopped here
+	if m.state.Unit != "" {
+		if err := stopUnit(m.state.Unit); err != nil {
+			return err
+		}
+	}
+
+	return m.v1.Delete()
+}
+
+// defaultSlice is the slice systemd units are created in without a slice in
+// cgroupsPath.
+const defaultSlice = "system.slice"
+
+// startUnit starts unit as a transient systemd unit in slice with pid in it.
+func startUnit(slice, unit string, pid int) error {
+	ctx := context.TODO()
+	conn, err := systemdDbus.NewWithContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ch := make(chan string, 1)
+	if _, err := conn.StartTransientUnitContext(ctx, unit, "replace", unitProperties(slice, unit, pid), ch); err != nil {
+		return fmt.Errorf("failed start unit %s: %w", unit, err)
+	}
+	if result := <-ch; result != "done" {
+		return fmt.Errorf("failed start unit %s: %s", unit, result)
+	}
+
+	return nil
+}
+
+// unitProperties returns the properties of the transient unit of a cgroup.
+// The cgroup is delegated, since noic writes the resources to the cgroup
+// files rather than passing them as unit properties.
+func unitProperties(slice, unit string, pid int) []systemdDbus.Property {
+	if slice == "" {
+		slice = defaultSlice
+	}
+
+	properties := []systemdDbus.Property{
+		systemdDbus.PropDescription("noic container " + unit),
+		systemdDbus.PropPids(uint32(pid)),
+		{Name: "DefaultDependencies", Value: dbus.MakeVariant(false)},
+		{Name: "Delegate", Value: dbus.MakeVariant(true)},
+		{Name: "MemoryAccounting", Value: dbus.MakeVariant(true)},
+		{Name: "CPUAccounting", Value: dbus.MakeVariant(true)},
+		{Name: "IOAccounting", Value: dbus.MakeVariant(true)},
+	}
+
+	// the parent of a slice is given by its name
+	if strings.HasSuffix(unit, ".slice") {
+		return append(properties, systemdDbus.PropWants(slice))
+	}
+
+	return append(properties, systemdDbus.PropSlice(slice))
+}
+
+// stopUnit stops the systemd unit of a cgroup. systemd removes a transient
+// scope by itself once its processes have exited, so a unit that is no longer
+// loaded is not an error.
+func stopUnit(unit string) error {
+	ctx := context.TODO()
+	conn, err := systemdDbus.NewWithContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ch := make(chan string)
+	if _, err := conn.StopUnitContext(ctx, unit, "replace", ch); err != nil {
+		if isUnitNotLoaded(err) {
+			return nil
+		}
+		return fmt.Errorf("failed stop unit %s: %w", unit, err)
+	}
+	<-ch
+
+	return nil
+}
+
+// isUnitNotLoaded reports whether err is the error systemd returns for a unit
+// it does not know.
+func isUnitNotLoaded(err error) bool {
+	var dbusErr dbus.Error
+	if errors.As(err, &dbusErr) {
+		return dbusErr.Name == "org.freedesktop.systemd1.NoSuchUnit"
+	}
+
+	return false
+}
+
+// killAll sends SIGKILL to the processes returned by procs until there are
+// none left.
+func killAll(procs func() ([]uint64, error)) error {
+	for i := 0; i < 100; i++ {
+		pids, err := procs()
+		if err != nil {
+			return err
+		}
+		if len(pids) == 0 {
+			return nil
+		}
+
+		for _, pid := range pids {
+			if err := unix.Kill(int(pid), unix.SIGKILL); err != nil && err != unix.ESRCH {
+				return fmt.Errorf("failed kill %d: %w", pid, err)
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return errors.New("processes are left in the cgroup")
+}
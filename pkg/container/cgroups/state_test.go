@@ -0,0 +1,105 @@
+package cgroups
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKillAll(t *testing.T) {
+	cmd := exec.Command("sleep", "100")
+	require.NoError(t, cmd.Start())
+
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+
+	procs := func() ([]uint64, error) {
+		select {
+		case <-exited:
+			return nil, nil
+		default:
+			return []uint64{uint64(cmd.Process.Pid)}, nil
+		}
+	}
+
+	require.NoError(t, killAll(procs))
+	assert.Equal(t, "signal: killed", cmd.ProcessState.String())
+}
+
+func TestIsUnitNotLoaded(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "no such unit",
+			err:  dbus.Error{Name: "org.freedesktop.systemd1.NoSuchUnit", Body: []interface{}{"Unit noic-t1.scope not loaded."}},
+			want: true,
+		},
+		{
+			name: "wrapped",
+			err:  fmt.Errorf("stop: %w", dbus.Error{Name: "org.freedesktop.systemd1.NoSuchUnit"}),
+			want: true,
+		},
+		{
+			name: "other dbus error",
+			err:  dbus.Error{Name: "org.freedesktop.DBus.Error.AccessDenied"},
+			want: false,
+		},
+		{
+			name: "other error",
+			err:  errors.New("connection refused"),
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isUnitNotLoaded(tt.err))
+		})
+	}
+}
+
+func TestDestroy_Deleted(t *testing.T) {
+	if IsVersion2() {
+		t.Skip("requires cgroup v1")
+	}
+
+	assert.NoError(t, Destroy(State{Path: "/noic-test-deleted"}))
+}
+
+func TestUnitProperties(t *testing.T) {
+	tests := []struct {
+		name      string
+		slice     string
+		unit      string
+		wantName  string
+		wantValue interface{}
+	}{
+		{name: "default slice", slice: "", unit: "noic-t1.scope", wantName: "Slice", wantValue: "system.slice"},
+		{name: "slice", slice: "my.slice", unit: "noic-t1.scope", wantName: "Slice", wantValue: "my.slice"},
+		{name: "slice unit", slice: "my.slice", unit: "my-t1.slice", wantName: "Wants", wantValue: []string{"my.slice"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			properties := map[string]interface{}{}
+			for _, p := range unitProperties(tt.slice, tt.unit, 10) {
+				properties[p.Name] = p.Value.Value()
+			}
+
+			assert.Equal(t, tt.wantValue, properties[tt.wantName])
+			assert.Equal(t, []uint32{10}, properties["PIDs"])
+			assert.Equal(t, true, properties["Delegate"])
+		})
+	}
+}
@@ -0,0 +1,376 @@
+package cgroups
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	cgroupsv1 "github.com/containerd/cgroups"
+	v1stats "github.com/containerd/cgroups/stats/v1"
+	v2stats "github.com/containerd/cgroups/v2/stats"
+	"golang.org/x/sys/unix"
+)
+
+// Stats is the resource usage of a cgroup, the same for cgroup v1 and v2.
+type Stats struct {
+	CPU     CPUStats                `json:"cpu"`
+	Memory  MemoryStats             `json:"memory"`
+	Pids    PidsStats               `json:"pids"`
+	IO      []IODeviceStats         `json:"io,omitempty"`
+	Hugetlb map[string]HugetlbStats `json:"hugetlb,omitempty"`
+	// Pressure is only available on cgroup v2 with PSI enabled
+	Pressure *PressureStats `json:"pressure,omitempty"`
+}
+
+// CPUStats are in nanoseconds.
+type CPUStats struct {
+	UsageTotal       uint64 `json:"usageTotal"`
+	UsageUser        uint64 `json:"usageUser"`
+	UsageKernel      uint64 `json:"usageKernel"`
+	Periods          uint64 `json:"periods"`
+	ThrottledPeriods uint64 `json:"throttledPeriods"`
+	ThrottledTime    uint64 `json:"throttledTime"`
+}
+
+// MemoryStats are in bytes. On cgroup v1, SwapLimit is the limit of memory+swap.
+type MemoryStats struct {
+	Usage     uint64       `json:"usage"`
+	Limit     uint64       `json:"limit"`
+	SwapUsage uint64       `json:"swapUsage"`
+	SwapLimit uint64       `json:"swapLimit"`
+	Cache     uint64       `json:"cache"`
+	Events    MemoryEvents `json:"events"`
+}
+
+// MemoryEvents count how often the memory limits were hit. Low, High and OOM
+// are always 0 on cgroup v1.
+type MemoryEvents struct {
+	Low     uint64 `json:"low"`
+	High    uint64 `json:"high"`
+	Max     uint64 `json:"max"`
+	OOM     uint64 `json:"oom"`
+	OOMKill uint64 `json:"oomKill"`
+}
+
+type PidsStats struct {
+	Current uint64 `json:"current"`
+	Limit   uint64 `json:"limit"`
+}
+
+type IODeviceStats struct {
+	Major      uint64 `json:"major"`
+	Minor      uint64 `json:"minor"`
+	ReadBytes  uint64 `json:"readBytes"`
+	WriteBytes uint64 `json:"writeBytes"`
+	ReadOps    uint64 `json:"readOps"`
+	WriteOps   uint64 `json:"writeOps"`
+}
+
+// HugetlbStats are in bytes, keyed by the page size. Failcnt is always 0 on
+// cgroup v2.
+type HugetlbStats struct {
+	Usage   uint64 `json:"usage"`
+	Max     uint64 `json:"max"`
+	Failcnt uint64 `json:"failcnt"`
+}
+
+type PressureStats struct {
+	CPU    *Pressure `json:"cpu,omitempty"`
+	Memory *Pressure `json:"memory,omitempty"`
+	IO     *Pressure `json:"io,omitempty"`
+}
+
+// Pressure is the content of a <resource>.pressure file.
+type Pressure struct {
+	Some PressureData `json:"some"`
+	Full PressureData `json:"full"`
+}
+
+type PressureData struct {
+	Avg10  float64 `json:"avg10"`
+	Avg60  float64 `json:"avg60"`
+	Avg300 float64 `json:"avg300"`
+	// Total is the total stall time in microseconds
+	Total uint64 `json:"total"`
+}
+
+// Stats returns the current resource usage of the cgroup.
+func (m *Manager) Stats() (*Stats, error) {
+	if m.v1 != nil {
+		metrics, err := m.v1.Stat(cgroupsv1.IgnoreNotExist)
+		if err != nil {
+			return nil, err
+		}
+		return statsFromV1(metrics), nil
+	}
+
+	metrics, err := m.v2.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := statsFromV2(metrics)
+	stats.Pressure, err = readPressureStats(m.path)
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+func statsFromV1(metrics *v1stats.Metrics) *Stats {
+	stats := &Stats{}
+
+	if cpu := metrics.CPU; cpu != nil {
+		if u := cpu.Usage; u != nil {
+			stats.CPU.UsageTotal = u.Total
+			stats.CPU.UsageUser = u.User
+			stats.CPU.UsageKernel = u.Kernel
+		}
+		if t := cpu.Throttling; t != nil {
+			stats.CPU.Periods = t.Periods
+			stats.CPU.ThrottledPeriods = t.ThrottledPeriods
+			stats.CPU.ThrottledTime = t.ThrottledTime
+		}
+	}
+
+	if memory := metrics.Memory; memory != nil {
+		stats.Memory.Cache = memory.Cache
+		if u := memory.Usage; u != nil {
+			stats.Memory.Usage = u.Usage
+			stats.Memory.Limit = u.Limit
+			stats.Memory.Events.Max = u.Failcnt
+		}
+		// the swap entry of cgroup v1 accounts memory+swap
+		if s := memory.Swap; s != nil {
+			stats.Memory.SwapLimit = s.Limit
+			if s.Usage > stats.Memory.Usage {
+				stats.Memory.SwapUsage = s.Usage - stats.Memory.Usage
+			}
+		}
+	}
+	if oom := metrics.MemoryOomControl; oom != nil {
+		stats.Memory.Events.OOMKill = oom.OomKill
+	}
+
+	if pids := metrics.Pids; pids != nil {
+		stats.Pids.Current = pids.Current
+		stats.Pids.Limit = pids.Limit
+	}
+
+	if blkio := metrics.Blkio; blkio != nil {
+		devices := map[[2]uint64]*IODeviceStats{}
+		device := func(e *v1stats.BlkIOEntry) *IODeviceStats {
+			key := [2]uint64{e.Major, e.Minor}
+			if devices[key] == nil {
+				devices[key] = &IODeviceStats{Major: e.Major, Minor: e.Minor}
+			}
+			return devices[key]
+		}
+
+		for _, e := range blkio.IoServiceBytesRecursive {
+			switch strings.ToLower(e.Op) {
+			case "read":
+				device(e).ReadBytes += e.Value
+			case "write":
+				device(e).WriteBytes += e.Value
+			}
+		}
+		for _, e := range blkio.IoServicedRecursive {
+			switch strings.ToLower(e.Op) {
+			case "read":
+				device(e).ReadOps += e.Value
+			case "write":
+				device(e).WriteOps += e.Value
+			}
+		}
+
+		for _, d := range devices {
+			stats.IO = append(stats.IO, *d)
+		}
+		sortIODevices(stats.IO)
+	}
+
+	for _, h := range metrics.Hugetlb {
+		if stats.Hugetlb == nil {
+			stats.Hugetlb = map[string]HugetlbStats{}
+		}
+		stats.Hugetlb[h.Pagesize] = HugetlbStats{Usage: h.Usage, Max: h.Max, Failcnt: h.Failcnt}
+	}
+
+	return stats
+}
+
+func statsFromV2(metrics *v2stats.Metrics) *Stats {
+	stats := &Stats{}
+
+	if cpu := metrics.CPU; cpu != nil {
+		stats.CPU.UsageTotal = cpu.UsageUsec * 1000
+		stats.CPU.UsageUser = cpu.UserUsec * 1000
+		stats.CPU.UsageKernel = cpu.SystemUsec * 1000
+		stats.CPU.Periods = cpu.NrPeriods
+		stats.CPU.ThrottledPeriods = cpu.NrThrottled
+		stats.CPU.ThrottledTime = cpu.ThrottledUsec * 1000
+	}
+
+	if memory := metrics.Memory; memory != nil {
+		stats.Memory.Usage = memory.Usage
+		stats.Memory.Limit = memory.UsageLimit
+		stats.Memory.SwapUsage = memory.SwapUsage
+		stats.Memory.SwapLimit = memory.SwapLimit
+		stats.Memory.Cache = memory.File
+	}
+	if events := metrics.MemoryEvents; events != nil {
+		stats.Memory.Events = MemoryEvents{
+			Low:     events.Low,
+			High:    events.High,
+			Max:     events.Max,
+			OOM:     events.Oom,
+			OOMKill: events.OomKill,
+		}
+	}
+
+	if pids := metrics.Pids; pids != nil {
+		stats.Pids.Current = pids.Current
+		stats.Pids.Limit = pids.Limit
+	}
+
+	if io := metrics.Io; io != nil {
+		for _, e := range io.Usage {
+			stats.IO = append(stats.IO, IODeviceStats{
+				Major:      e.Major,
+				Minor:      e.Minor,
+				ReadBytes:  e.Rbytes,
+				WriteBytes: e.Wbytes,
+				ReadOps:    e.Rios,
+				WriteOps:   e.Wios,
+			})
+		}
+		sortIODevices(stats.IO)
+	}
+
+	for _, h := range metrics.Hugetlb {
+		if stats.Hugetlb == nil {
+			stats.Hugetlb = map[string]HugetlbStats{}
+		}
+		stats.Hugetlb[h.Pagesize] = HugetlbStats{Usage: h.Current, Max: h.Max}
+	}
+
+	return stats
+}
+
+func sortIODevices(devices []IODeviceStats) {
+	sort.Slice(devices, func(i, j int) bool {
+		if devices[i].Major != devices[j].Major {
+			return devices[i].Major < devices[j].Major
+		}
+		return devices[i].Minor < devices[j].Minor
+	})
+}
+
+// readPressureStats reads the PSI files of the cgroup v2 at path. It returns
+// nil when the kernel does not provide them.
+func readPressureStats(path string) (*PressureStats, error) {
+	stats := &PressureStats{}
+	found := false
+	for _, p := range []struct {
+		file     string
+		pressure **Pressure
+	}{
+		{"cpu.pressure", &stats.CPU},
+		{"memory.pressure", &stats.Memory},
+		{"io.pressure", &stats.IO},
+	} {
+		f, err := os.Open(filepath.Join(path, p.file))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		pressure, err := parsePressure(f)
+		f.Close()
+		if err != nil {
+			// reading fails with EOPNOTSUPP when PSI is disabled
+			if errors.Is(err, errPressureNotSupported) {
+				continue
+			}
+			return nil, fmt.Errorf("failed parse %s: %w", p.file, err)
+		}
+
+		*p.pressure = pressure
+		found = true
+	}
+
+	if !found {
+		return nil, nil
+	}
+
+	return stats, nil
+}
+
+var errPressureNotSupported = errors.New("pressure stall information is not supported")
+
+// parsePressure parses the content of a <resource>.pressure file, e.g.
+//
+//	some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+//	full avg10=0.00 avg60=0.00 avg300=0.00 total=0
+func parsePressure(r io.Reader) (*Pressure, error) {
+	pressure := &Pressure{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		var data *PressureData
+		switch fields[0] {
+		case "some":
+			data = &pressure.Some
+		case "full":
+			data = &pressure.Full
+		default:
+			return nil, fmt.Errorf("invalid pressure line %q", scanner.Text())
+		}
+
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid pressure field %q", field)
+			}
+
+			var err error
+			switch key {
+			case "avg10":
+				data.Avg10, err = strconv.ParseFloat(value, 64)
+			case "avg60":
+				data.Avg60, err = strconv.ParseFloat(value, 64)
+			case "avg300":
+				data.Avg300, err = strconv.ParseFloat(value, 64)
+			case "total":
+				data.Total, err = strconv.ParseUint(value, 10, 64)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid pressure field %q: %w", field, err)
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, unix.EOPNOTSUPP) {
+			return nil, errPressureNotSupported
+		}
+		return nil, err
+	}
+
+	return pressure, nil
+}
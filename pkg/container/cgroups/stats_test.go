@@ -0,0 +1,163 @@
+package cgroups
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	v1stats "github.com/containerd/cgroups/stats/v1"
+	v2stats "github.com/containerd/cgroups/v2/stats"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsFromV1(t *testing.T) {
+	metrics := &v1stats.Metrics{
+		CPU: &v1stats.CPUStat{
+			Usage:      &v1stats.CPUUsage{Total: 300, Kernel: 100, User: 200},
+			Throttling: &v1stats.Throttle{Periods: 10, ThrottledPeriods: 2, ThrottledTime: 5000},
+		},
+		Memory: &v1stats.MemoryStat{
+			Cache: 4096,
+			Usage: &v1stats.MemoryEntry{Usage: 8192, Limit: 16384, Failcnt: 3},
+			Swap:  &v1stats.MemoryEntry{Usage: 12288, Limit: 32768},
+		},
+		MemoryOomControl: &v1stats.MemoryOomControl{OomKill: 1},
+		Pids:             &v1stats.PidsStat{Current: 4, Limit: 100},
+		Blkio: &v1stats.BlkIOStat{
+			IoServiceBytesRecursive: []*v1stats.BlkIOEntry{
+				{Op: "Read", Major: 8, Minor: 16, Value: 1024},
+				{Op: "Write", Major: 8, Minor: 16, Value: 2048},
+				{Op: "Total", Major: 8, Minor: 16, Value: 3072},
+				{Op: "Read", Major: 8, Minor: 0, Value: 512},
+			},
+			IoServicedRecursive: []*v1stats.BlkIOEntry{
+				{Op: "Read", Major: 8, Minor: 16, Value: 1},
+				{Op: "Write", Major: 8, Minor: 16, Value: 2},
+			},
+		},
+		Hugetlb: []*v1stats.HugetlbStat{{Pagesize: "2MB", Usage: 2097152, Max: 4194304, Failcnt: 1}},
+	}
+
+	assert.Equal(t, &Stats{
+		CPU: CPUStats{UsageTotal: 300, UsageUser: 200, UsageKernel: 100, Periods: 10, ThrottledPeriods: 2, ThrottledTime: 5000},
+		Memory: MemoryStats{
+			Usage:     8192,
+			Limit:     16384,
+			SwapUsage: 4096,
+			SwapLimit: 32768,
+			Cache:     4096,
+			Events:    MemoryEvents{Max: 3, OOMKill: 1},
+		},
+		Pids: PidsStats{Current: 4, Limit: 100},
+		IO: []IODeviceStats{
+			{Major: 8, Minor: 0, ReadBytes: 512},
+			{Major: 8, Minor: 16, ReadBytes: 1024, WriteBytes: 2048, ReadOps: 1, WriteOps: 2},
+		},
+		Hugetlb: map[string]HugetlbStats{"2MB": {Usage: 2097152, Max: 4194304, Failcnt: 1}},
+	}, statsFromV1(metrics))
+}
+
+func TestStatsFromV2(t *testing.T) {
+	metrics := &v2stats.Metrics{
+		CPU: &v2stats.CPUStat{UsageUsec: 3, UserUsec: 2, SystemUsec: 1, NrPeriods: 10, NrThrottled: 2, ThrottledUsec: 5},
+		Memory: &v2stats.MemoryStat{
+			Usage:      8192,
+			UsageLimit: 16384,
+			SwapUsage:  4096,
+			SwapLimit:  32768,
+			File:       1024,
+		},
+		MemoryEvents: &v2stats.MemoryEvents{Low: 1, High: 2, Max: 3, Oom: 4, OomKill: 5},
+		Pids:         &v2stats.PidsStat{Current: 4, Limit: 100},
+		Io: &v2stats.IOStat{Usage: []*v2stats.IOEntry{
+			{Major: 8, Minor: 16, Rbytes: 1024, Wbytes: 2048, Rios: 1, Wios: 2},
+			{Major: 8, Minor: 0, Rbytes: 512},
+		}},
+		Hugetlb: []*v2stats.HugeTlbStat{{Pagesize: "2MB", Current: 2097152, Max: 4194304}},
+	}
+
+	assert.Equal(t, &Stats{
+		CPU: CPUStats{UsageTotal: 3000, UsageUser: 2000, UsageKernel: 1000, Periods: 10, ThrottledPeriods: 2, ThrottledTime: 5000},
+		Memory: MemoryStats{
+			Usage:     8192,
+			Limit:     16384,
+			SwapUsage: 4096,
+			SwapLimit: 32768,
+			Cache:     1024,
+			Events:    MemoryEvents{Low: 1, High: 2, Max: 3, OOM: 4, OOMKill: 5},
+		},
+		Pids: PidsStats{Current: 4, Limit: 100},
+		IO: []IODeviceStats{
+			{Major: 8, Minor: 0, ReadBytes: 512},
+			{Major: 8, Minor: 16, ReadBytes: 1024, WriteBytes: 2048, ReadOps: 1, WriteOps: 2},
+		},
+		Hugetlb: map[string]HugetlbStats{"2MB": {Usage: 2097152, Max: 4194304}},
+	}, statsFromV2(metrics))
+}
+
+func TestParsePressure(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    *Pressure
+		wantErr bool
+	}{
+		{
+			name: "some and full",
+			content: "some avg10=1.50 avg60=0.25 avg300=0.00 total=12345\n" +
+				"full avg10=0.50 avg60=0.00 avg300=0.00 total=678\n",
+			want: &Pressure{
+				Some: PressureData{Avg10: 1.5, Avg60: 0.25, Total: 12345},
+				Full: PressureData{Avg10: 0.5, Total: 678},
+			},
+		},
+		{
+			name:    "some only",
+			content: "some avg10=0.00 avg60=0.00 avg300=0.00 total=1\n",
+			want:    &Pressure{Some: PressureData{Total: 1}},
+		},
+		{
+			name:    "invalid line",
+			content: "any avg10=0.00\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid field",
+			content: "some avg10\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid value",
+			content: "some total=-1\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePressure(strings.NewReader(tt.content))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestReadPressureStats(t *testing.T) {
+	dir := t.TempDir()
+
+	stats, err := readPressureStats(dir)
+	require.NoError(t, err)
+	assert.Nil(t, stats)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "memory.pressure"), []byte("some avg10=0.00 avg60=0.00 avg300=0.00 total=7\n"), 0o644))
+
+	stats, err = readPressureStats(dir)
+	require.NoError(t, err)
+	assert.Equal(t, &PressureStats{Memory: &Pressure{Some: PressureData{Total: 7}}}, stats)
+}
@@ -0,0 +1,116 @@
+package cgroups
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// unifiedControllers returns the controllers the unified resources belong to.
+func unifiedControllers(unified map[string]string) []string {
+	seen := map[string]bool{}
+	var controllers []string
+	for key := range unified {
+		controller, _, _ := strings.Cut(key, ".")
+		if controller == "" || controller == "cgroup" || seen[controller] {
+			continue
+		}
+		seen[controller] = true
+		controllers = append(controllers, controller)
+	}
+	sort.Strings(controllers)
+
+	return controllers
+}
+
+// unifiedCoreFiles are the files of the cgroup core that may be set as
+// unified resources. The others, e.g. cgroup.procs or cgroup.subtree_control,
+// move processes or change the hierarchy rather than limit the resources.
+var unifiedCoreFiles = map[string]bool{
+	"cgroup.freeze":          true,
+	"cgroup.max.depth":       true,
+	"cgroup.max.descendants": true,
+}
+
+// validateUnifiedKey checks that key names a file of one of the controllers
+// enabled in the cgroup, or one of unifiedCoreFiles, which are always
+// available.
+func validateUnifiedKey(key string, enabled map[string]bool) error {
+	if key == "" || strings.Contains(key, "/") || key == "." || key == ".." {
+		return fmt.Errorf("invalid unified key %q", key)
+	}
+
+	controller, file, ok := strings.Cut(key, ".")
+	if !ok || controller == "" || file == "" {
+		return fmt.Errorf("invalid unified key %q: expect the format \"controller.file\"", key)
+	}
+
+	if controller == "cgroup" && !unifiedCoreFiles[key] {
+		return fmt.Errorf("invalid unified key %q: only %s of the cgroup core can be set", key, strings.Join(sortedKeys(unifiedCoreFiles), ", "))
+	}
+
+	if controller != "cgroup" && !enabled[controller] {
+		return fmt.Errorf("invalid unified key %q: controller %s is not enabled", key, controller)
+	}
+
+	return nil
+}
+
+// setUnified writes the unified resources to the cgroup v2 at path. They are
+// written after the structured resources, so that they take precedence.
+func setUnified(path string, unified map[string]string) error {
+	if len(unified) == 0 {
+		return nil
+	}
+
+	if err := validateUnified(path, unified); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(unified))
+	for key := range unified {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	values := make([]v2Value, 0, len(keys))
+	for _, key := range keys {
+		values = append(values, v2Value{file: key, value: unified[key]})
+	}
+
+	return writeV2Values(path, values)
+}
+
+// validateUnified checks the keys of the unified resources against the
+// controllers available in the cgroup v2 at path.
+func validateUnified(path string, unified map[string]string) error {
+	b, err := os.ReadFile(filepath.Join(path, "cgroup.controllers"))
+	if err != nil {
+		return err
+	}
+
+	enabled := map[string]bool{}
+	for _, c := range strings.Fields(string(b)) {
+		enabled[c] = true
+	}
+
+	for key := range unified {
+		if err := validateUnifiedKey(key, enabled); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,104 @@
+package cgroups
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	cgroupsv2 "github.com/containerd/cgroups/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateUnifiedKey(t *testing.T) {
+	enabled := map[string]bool{"cpu": true, "memory": true}
+
+	tests := []struct {
+		key     string
+		wantErr bool
+	}{
+		{key: "memory.high", wantErr: false},
+		{key: "memory.oom.group", wantErr: false},
+		{key: "cpu.idle", wantErr: false},
+		{key: "cgroup.freeze", wantErr: false},
+		{key: "cgroup.max.descendants", wantErr: false},
+		{key: "cgroup.procs", wantErr: true},
+		{key: "cgroup.threads", wantErr: true},
+		{key: "cgroup.subtree_control", wantErr: true},
+		{key: "cgroup.kill", wantErr: true},
+		{key: "io.latency", wantErr: true},
+		{key: "memory", wantErr: true},
+		{key: ".high", wantErr: true},
+		{key: "memory.", wantErr: true},
+		{key: "../memory.high", wantErr: true},
+		{key: "child/memory.high", wantErr: true},
+		{key: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			err := validateUnifiedKey(tt.key, enabled)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestUnifiedControllers(t *testing.T) {
+	unified := map[string]string{
+		"memory.high":      "1G",
+		"memory.oom.group": "1",
+		"cpu.idle":         "1",
+		"cgroup.freeze":    "0",
+	}
+
+	assert.Equal(t, []string{"cpu", "memory"}, unifiedControllers(unified))
+}
+
+func TestSetUnified(t *testing.T) {
+	path := t.TempDir()
+	for file, content := range map[string]string{
+		"cgroup.controllers": "cpu memory pids\n",
+		"memory.high":        "max\n",
+		"cpu.idle":           "0\n",
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(path, file), []byte(content), 0o644))
+	}
+
+	require.NoError(t, setUnified(path, map[string]string{"memory.high": "1073741824", "cpu.idle": "1"}))
+
+	b, err := os.ReadFile(filepath.Join(path, "memory.high"))
+	require.NoError(t, err)
+	assert.Equal(t, "1073741824", string(b))
+
+	b, err = os.ReadFile(filepath.Join(path, "cpu.idle"))
+	require.NoError(t, err)
+	assert.Equal(t, "1", string(b))
+
+	// the controller is not enabled
+	assert.Error(t, setUnified(path, map[string]string{"io.latency": "8:0 target=10"}))
+	// the file does not exist
+	assert.Error(t, setUnified(path, map[string]string{"memory.unknown": "1"}))
+	_, err = os.Stat(filepath.Join(path, "memory.unknown"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestManagerSetUnified_UnknownController(t *testing.T) {
+	mountpoint := unifiedMountpointForTest(t)
+
+	path, err := os.MkdirTemp(mountpoint, "noic-test-")
+	require.NoError(t, err)
+	defer os.Remove(path)
+
+	v2, err := cgroupsv2.LoadManager(mountpoint, "/"+filepath.Base(path))
+	require.NoError(t, err)
+	m := &Manager{v2: v2, path: path}
+
+	// the controller cannot be enabled, which is reported with the key
+	err = m.setUnified(map[string]string{"unknown.max": "1"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `"unknown.max"`)
+}
@@ -0,0 +1,128 @@
+package cgroups
+
+import (
+	"os"
+
+	cgroupsv1 "github.com/containerd/cgroups"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// v1Controllers are the cgroup v1 controllers the container is placed in.
+var v1Controllers = map[cgroupsv1.Name]bool{
+	cgroupsv1.SystemdDbus: true,
+	cgroupsv1.Cpu:         true,
+	cgroupsv1.Cpuacct:     true,
+	cgroupsv1.Cpuset:      true,
+	cgroupsv1.Memory:      true,
+	cgroupsv1.Pids:        true,
+	cgroupsv1.Blkio:       true,
+	cgroupsv1.Devices:     true,
+	cgroupsv1.Hugetlb:     true,
+	cgroupsv1.NetCLS:      true,
+	cgroupsv1.NetPrio:     true,
+	cgroupsv1.Freezer:     true,
+}
+
+// newV1 creates the cgroup of the container in every cgroup v1 hierarchy,
+// applies the resource limits and moves config.Pid into it.
+func newV1(config *CgroupConfig) (*Manager, error) {
+	if len(config.Resources.Unified) > 0 {
+		return nil, errUnifiedOnV1
+	}
+
+	state := State{Path: fsGroupPath(config)}
+	path := cgroupsv1.StaticPath(state.Path)
+	if config.UseSystemd {
+		if err := parseSystemdPath(config); err != nil {
+			return nil, err
+		}
+		state = State{Slice: config.parent, Unit: getUnitName(config)}
+		path = cgroupsv1.Slice(state.Slice, state.Unit)
+		state.Path, _ = path("")
+	}
+
+	hierarchy := v1Hierarchy(config.UseSystemd)
+	control, err := cgroupsv1.New(hierarchy, path, v1Resources(config.Resources))
+	if err != nil {
+		removeV1(hierarchy, path, state.Unit)
+		return nil, err
+	}
+
+	if err := control.Add(cgroupsv1.Process{Pid: config.Pid}); err != nil {
+		removeV1(hierarchy, path, state.Unit)
+		return nil, err
+	}
+
+	return &Manager{v1: control, state: state}, nil
+}
+
+// removeV1 removes what newV1 has created of the cgroup at path before it
+// failed, which may be the cgroup of only some of the controllers.
+func removeV1(hierarchy cgroupsv1.Hierarchy, path cgroupsv1.Path, unit string) {
+	if unit != "" {
+		stopUnit(unit)
+	}
+
+	subsystems, err := hierarchy()
+	if err != nil {
+		return
+	}
+
+	for _, s := range subsystems {
+		p, ok := s.(interface{ Path(string) string })
+		if !ok {
+			continue
+		}
+		if name, err := path(s.Name()); err == nil {
+			os.Remove(p.Path(name))
+		}
+	}
+}
+
+// v1Resources returns resources with defaultDeviceRules added to the device
+// rules, as on cgroup v2. A rule without a type applies to all devices, which
+// devices.allow and devices.deny only accept as "a".
+func v1Resources(resources *specs.LinuxResources) *specs.LinuxResources {
+	r := *resources
+	r.Devices = withDefaultDeviceRules(resources.Devices)
+	for i := range r.Devices {
+		if r.Devices[i].Type == "" {
+			r.Devices[i].Type = "a"
+		}
+	}
+	return &r
+}
+
+// v1Hierarchy returns the hierarchy of the controllers in v1Controllers. With
+// useSystemd, the cgroup is created as a transient systemd unit.
+func v1Hierarchy(useSystemd bool) cgroupsv1.Hierarchy {
+	return func() ([]cgroupsv1.Subsystem, error) {
+		hierarchy := cgroupsv1.V1
+		if useSystemd {
+			hierarchy = cgroupsv1.Systemd
+		}
+
+		subsystems, err := hierarchy()
+		if err != nil {
+			return nil, err
+		}
+
+		var enabled []cgroupsv1.Subsystem
+		for _, s := range subsystems {
+			if !v1Controllers[s.Name()] {
+				continue
+			}
+
+			// skip the controllers that are not mounted on the host
+			if p, ok := s.(interface{ Path(string) string }); ok {
+				if _, err := os.Lstat(p.Path("/")); err != nil {
+					continue
+				}
+			}
+
+			enabled = append(enabled, s)
+		}
+
+		return enabled, nil
+	}
+}
@@ -3,13 +3,18 @@ package container
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strconv"
+	"syscall"
 
+	"github.com/mrtc0/noic/pkg/container/apparmor"
+	"github.com/mrtc0/noic/pkg/container/cgroups"
+	"github.com/mrtc0/noic/pkg/container/mount"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 	gopsutil "github.com/shirou/gopsutil/process"
+	"github.com/sirupsen/logrus"
 )
 
 const execFifoFilename = "exec.fifo"
@@ -27,7 +32,182 @@ type Container struct {
 	State              specs.State
 	StateRootDirectory string
 	UseSystemdCgroups  bool
-	ConsoleSocket string
+	ConsoleSocket      string
+
+	IgnoreUnknownCapabilities bool
+	ApparmorProfile           *apparmor.Profile
+	Overlay                   *mount.Overlay
+	Cgroup                    *cgroups.State
+	// OOMKilled is set once the OOM killer has killed a process of the container
+	OOMKilled bool
+}
+
+// setupCgroups creates the cgroup of the container, applies the resource
+// limits and moves the init process into it. The cgroup is created even
+// without resources or a cgroupsPath, so that the container can be watched
+// and only the default devices are allowed when the spec has no device rules.
+func (c *Container) setupCgroups(pid int) error {
+	resources := c.Spec.Linux.Resources
+	if resources == nil {
+		resources = &specs.LinuxResources{}
+	}
+
+	oomGroup, err := cgroups.OOMGroupFromAnnotations(c.Spec.Annotations)
+	if err != nil {
+		return err
+	}
+
+	config := &cgroups.CgroupConfig{
+		UseSystemd: c.UseSystemdCgroups,
+		CgroupPath: c.Spec.Linux.CgroupsPath,
+		Resources:  resources,
+		Name:       c.ID,
+		Pid:        pid,
+		OOMGroup:   oomGroup,
+	}
+
+	m, err := cgroups.New(config)
+	if err != nil {
+		return err
+	}
+
+	state := m.State()
+	c.Cgroup = &state
+	return nil
+}
+
+// Update applies resources to the cgroup of the container and saves them
+// in the state.
+func (c *Container) Update(resources *specs.LinuxResources) error {
+	if c.Cgroup == nil {
+		return fmt.Errorf("container %s has no cgroup", c.ID)
+	}
+
+	m, err := cgroups.Load(*c.Cgroup)
+	if err != nil {
+		return err
+	}
+
+	if err := m.Update(resources); err != nil {
+		return fmt.Errorf("failed update cgroup: %s", err)
+	}
+
+	c.Spec.Linux.Resources = resources
+	return c.SaveState()
+}
+
+// Stats returns the resource usage of the container's cgroup.
+func (c *Container) Stats() (*cgroups.Stats, error) {
+	if c.Cgroup == nil {
+		return nil, fmt.Errorf("container %s has no cgroup", c.ID)
+	}
+
+	m, err := cgroups.Load(*c.Cgroup)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.Stats()
+}
+
+// UpdateOOMKilled marks the container as OOM-killed and saves the state when
+// the memory events in stats report a kill by the OOM killer.
+func (c *Container) UpdateOOMKilled(stats *cgroups.Stats) error {
+	if c.OOMKilled || stats.Memory.Events.OOMKill == 0 {
+		return nil
+	}
+
+	return c.markOOMKilled()
+}
+
+// markOOMKilled marks the container as OOM-killed and saves the state.
+func (c *Container) markOOMKilled() error {
+	c.OOMKilled = true
+
+	// the state is read again, so that the changes made since c was loaded,
+	// e.g. by noic update, are kept
+	latest, err := FindByID(c.ID, c.StateRootDirectory)
+	if err != nil {
+		return err
+	}
+	latest.OOMKilled = true
+
+	return latest.SaveState()
+}
+
+// CheckOOMKilled reports whether the OOM killer has killed a process of the
+// container, reading the memory events of the cgroup as long as it exists.
+func (c *Container) CheckOOMKilled() (bool, error) {
+	if c.OOMKilled || c.Cgroup == nil || !cgroups.Exists(*c.Cgroup) {
+		return c.OOMKilled, nil
+	}
+
+	stats, err := c.Stats()
+	if err != nil {
+		return false, err
+	}
+
+	if err := c.UpdateOOMKilled(stats); err != nil {
+		return false, err
+	}
+
+	return c.OOMKilled, nil
+}
+
+// WatchOOMKill blocks until the OOM killer kills a process of the container
+// or its cgroup is removed, and marks the container as OOM-killed. Unlike
+// CheckOOMKilled, it records the kill even when the cgroup is removed as soon
+// as the container exits, as systemd does with the scope of the container.
+func (c *Container) WatchOOMKill() error {
+	if c.Cgroup == nil || !cgroups.Exists(*c.Cgroup) {
+		return nil
+	}
+
+	m, err := cgroups.Load(*c.Cgroup)
+	if err != nil {
+		return err
+	}
+
+	killed, err := m.WatchOOMKill()
+	if err != nil || !killed {
+		return err
+	}
+
+	return c.markOOMKilled()
+}
+
+// StartOOMWatcher starts a detached "noic oom-watch" that records an OOM kill
+// of the container while no other noic command is running. The state of the
+// container must have been saved.
+func (c *Container) StartOOMWatcher() error {
+	cmd := exec.Command("/proc/self/exe", "--root", c.StateRootDirectory, "oom-watch", c.ID)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed start oom watcher: %w", err)
+	}
+
+	return cmd.Process.Release()
+}
+
+// WatchPressure calls fn each time one of the PSI triggers set by the
+// annotations of the container fires, until stop is closed or the cgroup is
+// removed. It returns at once when no trigger is set.
+func (c *Container) WatchPressure(stop <-chan struct{}, fn func(cgroups.PressureTrigger)) error {
+	triggers, err := cgroups.PressureTriggersFromAnnotations(c.Spec.Annotations)
+	if err != nil || len(triggers) == 0 {
+		return err
+	}
+
+	if c.Cgroup == nil {
+		return fmt.Errorf("container %s has no cgroup", c.ID)
+	}
+
+	m, err := cgroups.Load(*c.Cgroup)
+	if err != nil {
+		return err
+	}
+
+	return m.WatchPressure(triggers, stop, fn)
 }
 
 func Exists(stateRootDirectory, containerID string) bool {
@@ -54,12 +234,26 @@ func (c *Container) SaveState() error {
 		return err
 	}
 
-	err = ioutil.WriteFile(c.StateFilePath(), j, 0644)
+	// the state is replaced atomically, since it is also read and written by
+	// other noic commands
+	f, err := os.CreateTemp(c.StateDirectory(), ".state.json-")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(f.Name())
 
-	return nil
+	_, err = f.Write(j)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), c.StateFilePath())
 }
 
 func FindByID(id string, stateRootDirectory string) (*Container, error) {
@@ -86,11 +280,26 @@ func (c *Container) Run() error {
 	if err != nil {
 		return fmt.Errorf("faild NewParentProcess: %s", err)
 	}
+	// the init has its own copies of the pipe, the console socket and the user
+	// namespaces of the idmapped mounts once started
+	defer func() {
+		for _, f := range parent.ExtraFiles {
+			f.Close()
+		}
+	}()
 
 	if err := parent.Start(); err != nil {
 		return fmt.Errorf("failed start parent Process: %s", err)
 	}
 
+	// the init blocks until it has read the container from the pipe, so the
+	// cgroups are in place before it does anything on behalf of the container.
+	if err := c.setupCgroups(parent.Process.Pid); err != nil {
+		parent.Process.Kill()
+		parent.Wait()
+		return fmt.Errorf("failed create cgroup: %s", err)
+	}
+
 	c.InitProcess = &InitProcess{Pid: parent.Process.Pid}
 	c.State.Pid = parent.Process.Pid
 	c.State.Status = specs.ContainerState(c.CurrentStatus().String())
@@ -106,6 +315,22 @@ func (c *Container) Run() error {
 }
 
 func (c *Container) Destroy() error {
+	// the overlay is only mounted in the mount namespace of the container, so
+	// it is gone with the container and its directories with the state
+	// directory
+
+	if c.Cgroup != nil {
+		// the memory events are removed with the cgroup, so an OOM kill that
+		// has not been recorded yet is recorded first
+		if _, err := c.CheckOOMKilled(); err != nil {
+			logrus.Warnf("failed check OOM kills: %s", err)
+		}
+
+		if err := cgroups.Destroy(*c.Cgroup); err != nil {
+			return fmt.Errorf("failed destroy cgroup: %s", err)
+		}
+	}
+
 	if err := os.RemoveAll(c.StateDirectory()); err != nil {
 		return err
 	}
@@ -0,0 +1,32 @@
+package container
+
+import (
+	"testing"
+
+	"github.com/mrtc0/noic/pkg/container/cgroups"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOOMKilled_CgroupRemoved(t *testing.T) {
+	root := t.TempDir()
+	c := &Container{
+		ID:                 "t1",
+		StateRootDirectory: root,
+		Cgroup:             &cgroups.State{Path: "/noic-test-removed/t1"},
+	}
+	require.NoError(t, c.SaveState())
+
+	stats := &cgroups.Stats{}
+	stats.Memory.Events.OOMKill = 1
+	require.NoError(t, c.UpdateOOMKilled(stats))
+
+	// the cgroup no longer exists, the kill is read from the state
+	loaded, err := FindByID("t1", root)
+	require.NoError(t, err)
+	assert.True(t, loaded.OOMKilled)
+
+	oomKilled, err := loaded.CheckOOMKilled()
+	require.NoError(t, err)
+	assert.True(t, oomKilled)
+}
@@ -5,6 +5,11 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/mrtc0/noic/pkg/container/apparmor"
+	"github.com/mrtc0/noic/pkg/container/capabilities"
+	"github.com/mrtc0/noic/pkg/container/cgroups"
+	"github.com/mrtc0/noic/pkg/container/landlock"
+	"github.com/mrtc0/noic/pkg/container/mount"
 	specsgo "github.com/opencontainers/runtime-spec/specs-go"
 )
 
@@ -14,6 +19,8 @@ type ContainerFactory struct {
 	BundlePath         string
 	UseSystemdCgroups  bool
 	ConsoleSocket      string
+
+	IgnoreUnknownCapabilities bool
 }
 
 func (f *ContainerFactory) Create() (*Container, error) {
@@ -28,6 +35,32 @@ func (f *ContainerFactory) Create() (*Container, error) {
 		return nil, err
 	}
 
+	// the capabilities are applied by the init, but an unknown one is
+	// reported by create rather than start
+	if _, err := capabilities.New(spec.Process.Capabilities, f.IgnoreUnknownCapabilities); err != nil {
+		return nil, err
+	}
+
+	if _, err := cgroups.PressureTriggersFromAnnotations(spec.Annotations); err != nil {
+		return nil, err
+	}
+
+	if _, err := cgroups.OOMGroupFromAnnotations(spec.Annotations); err != nil {
+		return nil, err
+	}
+
+	if landlock.FromAnnotations(spec.Annotations) != nil && !spec.Process.NoNewPrivileges {
+		return nil, fmt.Errorf("landlock annotations require process.noNewPrivileges")
+	}
+
+	var apparmorProfile *apparmor.Profile
+	if spec.Process.ApparmorProfile != "" {
+		apparmorProfile, err = apparmor.LookupProfile(spec.Process.ApparmorProfile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	/*
 		_, err = os.Stat(containerRoot)
 		if os.IsNotExist(err) {
@@ -50,6 +83,15 @@ func (f *ContainerFactory) Create() (*Container, error) {
 		rootfsPath = filepath.Join(cwd, rootfsPath)
 	}
 
+	overlay, err := mount.NewOverlay(rootfsPath, cwd, spec.Annotations, filepath.Join(p, "overlay"))
+	if err != nil {
+		return nil, err
+	}
+
+	if overlay != nil {
+		rootfsPath = overlay.MergedDir
+	}
+
 	execFifoPath := filepath.Join(f.StateRootDirectory, f.ContainerID, execFifoFilename)
 	c := &Container{
 		ID:           f.ContainerID,
@@ -66,6 +108,10 @@ func (f *ContainerFactory) Create() (*Container, error) {
 		StateRootDirectory: f.StateRootDirectory,
 		UseSystemdCgroups:  f.UseSystemdCgroups,
 		ConsoleSocket:      f.ConsoleSocket,
+
+		IgnoreUnknownCapabilities: f.IgnoreUnknownCapabilities,
+		ApparmorProfile:           apparmorProfile,
+		Overlay:                   overlay,
 	}
 
 	return c, nil
@@ -2,19 +2,21 @@ package container
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"runtime"
 	"strconv"
+	"strings"
 	"syscall"
 
 	"github.com/mrtc0/noic/pkg/container/apparmor"
 	"github.com/mrtc0/noic/pkg/container/capabilities"
-	"github.com/mrtc0/noic/pkg/container/cgroups"
+	"github.com/mrtc0/noic/pkg/container/landlock"
 	"github.com/mrtc0/noic/pkg/container/mount"
 	"github.com/mrtc0/noic/pkg/container/processes"
 	"github.com/mrtc0/noic/pkg/container/seccomp"
+	"github.com/mrtc0/noic/pkg/container/selinux"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 	"golang.org/x/sys/unix"
@@ -22,6 +24,10 @@ import (
 
 func Init(ctx *cli.Context, pipe *os.File) error {
 	logrus.Debug("init start")
+	// credentials and capabilities are per-thread, so everything up to
+	// execve must happen on the same OS thread.
+	runtime.LockOSThread()
+
 	var container *Container
 	if err := json.NewDecoder(pipe).Decode(&container); err != nil {
 		return err
@@ -44,19 +50,8 @@ func Init(ctx *cli.Context, pipe *os.File) error {
 		return err
 	}
 
-	if container.Spec.Linux.Resources != nil && container.Spec.Linux.CgroupsPath != "" {
-		c := &cgroups.CgroupConfig{
-			UseSystemd: container.UseSystemdCgroups,
-			CgroupPath: container.Spec.Linux.CgroupsPath,
-			Resources:  container.Spec.Linux.Resources,
-			Name:       container.ID,
-			Pid:        container.InitProcess.Pid,
-		}
-
-		_, err := cgroups.New(c)
-		if err != nil {
-			return fmt.Errorf("failed create cgroup: %s", err)
-		}
+	if !selinux.Enabled() && (container.Spec.Process.SelinuxLabel != "" || container.Spec.Linux.MountLabel != "") {
+		logrus.Warn("SELinux is disabled on the host, ignoring selinux labels")
 	}
 
 	if container.Spec.Process.Rlimits != nil {
@@ -65,10 +60,19 @@ func Init(ctx *cli.Context, pipe *os.File) error {
 		}
 	}
 
-	if err := mount.MountRootFs(container.Root, container.Spec); err != nil {
+	idmapUserns, err := idmapUsernsFromEnv()
+	if err != nil {
+		return err
+	}
+
+	if err := mount.MountRootFs(container.Root, container.Spec, idmapUserns, container.Overlay); err != nil {
 		return err
 	}
 
+	for _, f := range idmapUserns {
+		f.Close()
+	}
+
 	if container.Spec.Process.Terminal {
 		if envConsole := os.Getenv("_NOIC_CONSOLE_FD"); envConsole != "" {
 			console, err := strconv.Atoi(envConsole)
@@ -84,7 +88,7 @@ func Init(ctx *cli.Context, pipe *os.File) error {
 		}
 	}
 
-	if err := readonlyPathMount(container.Spec.Linux.ReadonlyPaths); err != nil {
+	if err := mount.MountReadonlyPaths(container.Spec.Linux.ReadonlyPaths); err != nil {
 		return err
 	}
 
@@ -94,7 +98,9 @@ func Init(ctx *cli.Context, pipe *os.File) error {
 		}
 	}
 
-	if container.Spec.Linux.Seccomp != nil {
+	// without no_new_privs, loading the seccomp profile requires CAP_SYS_ADMIN,
+	// so it is loaded before the capabilities are dropped
+	if container.Spec.Linux.Seccomp != nil && !container.Spec.Process.NoNewPrivileges {
 		if err := seccomp.LoadSeccompProfile(*container.Spec.Linux.Seccomp); err != nil {
 			return err
 		}
@@ -106,11 +112,29 @@ func Init(ctx *cli.Context, pipe *os.File) error {
 		}
 	}
 
-	if container.Spec.Process.Capabilities != nil {
-		cap := capabilities.New(*container.Spec.Process.Capabilities)
-		if err := cap.Apply(); err != nil {
-			return fmt.Errorf("failed apply capabilities: %v", err)
-		}
+	cap, err := capabilities.New(container.Spec.Process.Capabilities, container.IgnoreUnknownCapabilities)
+	if err != nil {
+		return err
+	}
+
+	if err := cap.ApplyBounding(); err != nil {
+		return fmt.Errorf("failed apply bounding capabilities: %v", err)
+	}
+
+	if err := capabilities.SetKeepCaps(); err != nil {
+		return err
+	}
+
+	if err := processes.SetupUser(container.Spec.Process.User); err != nil {
+		return err
+	}
+
+	if err := capabilities.ClearKeepCaps(); err != nil {
+		return err
+	}
+
+	if err := cap.ApplyCaps(); err != nil {
+		return fmt.Errorf("failed apply capabilities: %v", err)
 	}
 
 	if err := os.Chdir(container.Spec.Process.Cwd); err != nil {
@@ -121,6 +145,27 @@ func Init(ctx *cli.Context, pipe *os.File) error {
 	if err != nil {
 		return fmt.Errorf("%s not found: %v", command[0], err)
 	}
+
+	if err := selinux.SetExecLabel(container.Spec.Process.SelinuxLabel); err != nil {
+		return err
+	}
+
+	// landlock requires no_new_privs, so it always comes before the seccomp
+	// profile, which then need not allow the landlock syscalls
+	if l := landlock.FromAnnotations(container.Spec.Annotations); l != nil {
+		if err := l.Apply(); err != nil {
+			return fmt.Errorf("failed apply landlock: %v", err)
+		}
+	}
+
+	// with no_new_privs, the seccomp profile is loaded last, so that it need not
+	// allow the syscalls the init makes to switch the user and the capabilities
+	if container.Spec.Linux.Seccomp != nil && container.Spec.Process.NoNewPrivileges {
+		if err := seccomp.LoadSeccompProfile(*container.Spec.Linux.Seccomp); err != nil {
+			return err
+		}
+	}
+
 	// Run a container process
 	if err := syscall.Exec(path, command[0:], container.Spec.Process.Env); err != nil {
 		return err
@@ -142,25 +187,25 @@ func awaitStart(path string) error {
 	return nil
 }
 
-func readonlyPathMount(paths []string) error {
-	for _, path := range paths {
-		if err := syscall.Mount(path, path, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
-			if errors.Is(err, os.ErrNotExist) {
-				continue
-			}
-			return err
-		}
+// idmapUsernsFromEnv returns the user namespaces for idmapped mounts passed
+// by the parent, keyed by the index of the mount.
+func idmapUsernsFromEnv() (map[int]*os.File, error) {
+	userns := map[int]*os.File{}
 
-		var s unix.Statfs_t
-		if err := unix.Statfs(path, &s); err != nil {
-			return &os.PathError{Op: "statfs", Path: path, Err: err}
-		}
-		flags := uintptr(s.Flags) & (unix.MS_NOSUID | unix.MS_NODEV | unix.MS_NOEXEC)
+	env := os.Getenv("_NOIC_IDMAP_FDS")
+	if env == "" {
+		return userns, nil
+	}
 
-		if err := syscall.Mount(path, path, "", flags|unix.MS_BIND|unix.MS_REMOUNT|unix.MS_RDONLY, ""); err != nil {
-			return err
+	// e.g. "2:5,4:6"
+	for _, pair := range strings.Split(env, ",") {
+		var index, fd int
+		if _, err := fmt.Sscanf(pair, "%d:%d", &index, &fd); err != nil {
+			return nil, fmt.Errorf("invalid _NOIC_IDMAP_FDS %s: %w", env, err)
 		}
+
+		userns[index] = os.NewFile(uintptr(fd), fmt.Sprintf("userns-%d", index))
 	}
 
-	return nil
+	return userns, nil
 }
@@ -0,0 +1,181 @@
+package landlock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unsafe"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// AnnotationReadOnly and AnnotationReadWrite restrict the container to
+	// the listed paths. They require process.noNewPrivileges, since
+	// landlock_restrict_self(2) requires no_new_privs once the init has
+	// dropped CAP_SYS_ADMIN.
+	AnnotationReadOnly  = "org.noic.landlock.ro"
+	AnnotationReadWrite = "org.noic.landlock.rw"
+
+	// not defined in x/sys yet, introduced in ABI version 3
+	accessFSTruncate = 0x4000
+)
+
+const (
+	accessFSRead = unix.LANDLOCK_ACCESS_FS_EXECUTE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_DIR
+
+	accessFSV1 = accessFSRead |
+		unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_DIR |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_FILE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_CHAR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_DIR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_REG |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_FIFO |
+		unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SYM
+	accessFSV2 = accessFSV1 | unix.LANDLOCK_ACCESS_FS_REFER
+	accessFSV3 = accessFSV2 | accessFSTruncate
+
+	// access rights that can be granted on a regular file
+	accessFile = unix.LANDLOCK_ACCESS_FS_EXECUTE |
+		unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		accessFSTruncate
+)
+
+type Config struct {
+	ReadOnly  []string
+	ReadWrite []string
+}
+
+// FromAnnotations returns nil when no landlock annotation is set.
+func FromAnnotations(annotations map[string]string) *Config {
+	ro, hasRO := annotations[AnnotationReadOnly]
+	rw, hasRW := annotations[AnnotationReadWrite]
+	if !hasRO && !hasRW {
+		return nil
+	}
+
+	return &Config{
+		ReadOnly:  splitPaths(ro),
+		ReadWrite: splitPaths(rw),
+	}
+}
+
+func splitPaths(s string) []string {
+	paths := []string{}
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			paths = append(paths, p)
+		}
+	}
+
+	return paths
+}
+
+func ABIVersion() (int, error) {
+	abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		return 0, errno
+	}
+
+	return int(abi), nil
+}
+
+func handledAccessFS(abi int) uint64 {
+	switch {
+	case abi >= 3:
+		return accessFSV3
+	case abi == 2:
+		return accessFSV2
+	case abi == 1:
+		return accessFSV1
+	default:
+		return 0
+	}
+}
+
+// isUnsupported reports whether err means that the kernel has no landlock.
+func isUnsupported(err error) bool {
+	return errors.Is(err, unix.ENOSYS) || errors.Is(err, unix.EOPNOTSUPP)
+}
+
+// Apply restricts the calling thread, and the process it executes, to the configured paths.
+// no_new_privs must already be set. Landlock is only skipped when the kernel does
+// not support it; any other failure is an error, so that the container never runs
+// without the restriction it asked for.
+func (c *Config) Apply() error {
+	abi, err := ABIVersion()
+	if isUnsupported(err) {
+		logrus.Warnf("landlock is not supported by the running kernel, skipping: %v", err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed get landlock ABI version: %w", err)
+	}
+
+	nnp, err := unix.PrctlRetInt(unix.PR_GET_NO_NEW_PRIVS, 0, 0, 0, 0)
+	if err != nil {
+		return fmt.Errorf("failed get no_new_privs: %w", err)
+	}
+	if nnp != 1 {
+		return errors.New("landlock requires process.noNewPrivileges")
+	}
+
+	handled := handledAccessFS(abi)
+	attr := unix.LandlockRulesetAttr{Access_fs: handled}
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("failed landlock_create_ruleset: %s", errno)
+	}
+	defer unix.Close(int(fd))
+
+	for _, path := range c.ReadOnly {
+		if err := addPathRule(int(fd), path, accessFSRead&handled); err != nil {
+			return err
+		}
+	}
+
+	for _, path := range c.ReadWrite {
+		if err := addPathRule(int(fd), path, handled); err != nil {
+			return err
+		}
+	}
+
+	if _, _, errno := unix.Syscall(unix.SYS_LANDLOCK_RESTRICT_SELF, fd, 0, 0); errno != 0 {
+		return fmt.Errorf("failed landlock_restrict_self: %s", errno)
+	}
+
+	return nil
+}
+
+func addPathRule(rulesetFd int, path string, access uint64) error {
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	defer unix.Close(fd)
+
+	var s unix.Stat_t
+	if err := unix.Fstat(fd, &s); err != nil {
+		return &os.PathError{Op: "fstat", Path: path, Err: err}
+	}
+
+	if s.Mode&unix.S_IFMT != unix.S_IFDIR {
+		access &= accessFile
+	}
+
+	attr := unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(fd)}
+	_, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(rulesetFd), unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&attr)), 0, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("failed landlock_add_rule %s: %s", path, errno)
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package landlock
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestFromAnnotations(t *testing.T) {
+	testCases := []struct {
+		name        string
+		annotations map[string]string
+		expected    *Config
+	}{
+		{
+			name:        "no landlock annotations",
+			annotations: map[string]string{"org.example": "foo"},
+			expected:    nil,
+		},
+		{
+			name: "read only and read write paths",
+			annotations: map[string]string{
+				AnnotationReadOnly:  "/usr, /etc,",
+				AnnotationReadWrite: "/data",
+			},
+			expected: &Config{
+				ReadOnly:  []string{"/usr", "/etc"},
+				ReadWrite: []string{"/data"},
+			},
+		},
+		{
+			name:        "read only paths only",
+			annotations: map[string]string{AnnotationReadOnly: "/"},
+			expected: &Config{
+				ReadOnly:  []string{"/"},
+				ReadWrite: []string{},
+			},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, FromAnnotations(test.annotations))
+		})
+	}
+}
+
+func TestHandledAccessFS(t *testing.T) {
+	assert.Equal(t, uint64(0), handledAccessFS(0))
+	assert.Zero(t, handledAccessFS(1)&unix.LANDLOCK_ACCESS_FS_REFER)
+	assert.NotZero(t, handledAccessFS(2)&unix.LANDLOCK_ACCESS_FS_REFER)
+	assert.Zero(t, handledAccessFS(2)&accessFSTruncate)
+	assert.Equal(t, uint64(accessFSV3), handledAccessFS(4))
+}
+
+func TestIsUnsupported(t *testing.T) {
+	assert.True(t, isUnsupported(unix.ENOSYS))
+	assert.True(t, isUnsupported(unix.EOPNOTSUPP))
+	assert.True(t, isUnsupported(fmt.Errorf("landlock: %w", unix.ENOSYS)))
+	// e.g. a seccomp profile denying the landlock syscalls
+	assert.False(t, isUnsupported(unix.EPERM))
+	assert.False(t, isUnsupported(errors.New("other")))
+	assert.False(t, isUnsupported(nil))
+}
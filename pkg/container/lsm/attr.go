@@ -0,0 +1,30 @@
+package lsm
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// WriteExecAttr writes value to the exec attr of the calling thread for the
+// security module (e.g. selinux or apparmor), falling back to the shared
+// attr/exec on kernels without per-module attr directories. The value takes
+// effect on the next execve.
+func WriteExecAttr(module, value string) error {
+	// attr files can only be written by the task they belong to, so use
+	// thread-self rather than self. The caller must keep the OS thread locked
+	// until execve.
+	path := filepath.Join("/proc/thread-self/attr", module, "exec")
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		path = "/proc/thread-self/attr/exec"
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(value)
+	return err
+}
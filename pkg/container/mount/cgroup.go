@@ -0,0 +1,239 @@
+package mount
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/mrtc0/noic/pkg/container/cgroups"
+)
+
+type cgroupV1Bind struct {
+	// host path of the container's cgroup in the hierarchy
+	Source string
+	// directory name under the cgroup mount, e.g. "cpu,cpuacct"
+	Name string
+	// controllers co-mounted in the hierarchy, e.g. "cpu", "cpuacct"
+	Controllers []string
+}
+
+func mountCgroup(rootfs, destination string, opts mountOptions) error {
+	if cgroups.IsVersion2() {
+		return mountCgroupV2(rootfs, destination, opts)
+	}
+
+	return mountCgroupV1(rootfs, destination, opts)
+}
+
+func mountCgroupV2(rootfs, destination string, opts mountOptions) error {
+	if err := createInRoot(rootfs, destination, true); err != nil {
+		return err
+	}
+
+	err := withProcfd(rootfs, destination, func(procfd string) error {
+		return syscall.Mount("cgroup2", procfd, "cgroup2", uintptr(opts.flags), opts.mountData())
+	})
+	if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EBUSY) {
+		// cgroup2 can't be mounted without a cgroup namespace in some
+		// environments, so fall back to bind mounting the container's own
+		// cgroup, as is done for cgroup v1.
+		source, err := ownCgroupV2()
+		if err != nil {
+			return err
+		}
+
+		opts.flags |= syscall.MS_BIND | syscall.MS_REC
+		return bindMount(source, rootfs, destination, opts)
+	}
+
+	return err
+}
+
+// ownCgroupV2 returns the host path of the cgroup v2 of the calling process.
+func ownCgroupV2() (string, error) {
+	mounts, err := readMountInfo()
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	paths, err := parseCgroupFile(f)
+	if err != nil {
+		return "", err
+	}
+
+	return cgroupV2Source(mounts, paths)
+}
+
+// cgroupV2Source returns the path of the cgroup v2 in paths under the cgroup2
+// mount of the host.
+func cgroupV2Source(mounts []mountInfo, paths map[string]string) (string, error) {
+	path, ok := paths[""]
+	if !ok {
+		return "", errors.New("no cgroup v2 entry in /proc/self/cgroup")
+	}
+
+	for _, m := range mounts {
+		if m.FSType != "cgroup2" {
+			continue
+		}
+
+		rel, err := filepath.Rel(m.Root, path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+
+		return filepath.Join(m.Mountpoint, rel), nil
+	}
+
+	return "", fmt.Errorf("cgroup %s is not under a cgroup2 mount", path)
+}
+
+// mountCgroupV1 mounts a tmpfs at destination and bind mounts the
+// container's own cgroup of every hierarchy into it, like runc does.
+func mountCgroupV1(rootfs, destination string, opts mountOptions) error {
+	mounts, err := readMountInfo()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	paths, err := parseCgroupFile(f)
+	if err != nil {
+		return err
+	}
+
+	binds := cgroupV1Binds(mounts, paths)
+
+	if err := createInRoot(rootfs, destination, true); err != nil {
+		return err
+	}
+
+	// the tmpfs is made read-only after the controllers are mounted on it
+	tmpfsFlags := opts.flags &^ (syscall.MS_RDONLY | syscall.MS_BIND | syscall.MS_REC)
+	err = withProcfd(rootfs, destination, func(procfd string) error {
+		return syscall.Mount("tmpfs", procfd, "tmpfs", uintptr(tmpfsFlags), "mode=755")
+	})
+	if err != nil {
+		return fmt.Errorf("failed mount tmpfs for cgroup: %s", err)
+	}
+
+	bindOpts := mountOptions{
+		flags:        syscall.MS_BIND | syscall.MS_REC | opts.flags&(syscall.MS_RDONLY|syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC),
+		clearedFlags: opts.clearedFlags,
+	}
+	for _, b := range binds {
+		dest := filepath.Join(destination, b.Name)
+		if err := bindMount(b.Source, rootfs, dest, bindOpts); err != nil {
+			return fmt.Errorf("failed bind mount cgroup %s: %s", b.Name, err)
+		}
+
+		if len(b.Controllers) < 2 {
+			continue
+		}
+
+		// e.g. cpu -> cpu,cpuacct
+		dir, err := SecureJoin(rootfs, destination)
+		if err != nil {
+			return err
+		}
+		for _, c := range b.Controllers {
+			if err := os.Symlink(b.Name, filepath.Join(dir, c)); err != nil && !os.IsExist(err) {
+				return err
+			}
+		}
+	}
+
+	if opts.flags&syscall.MS_RDONLY != 0 {
+		err := withProcfd(rootfs, destination, func(procfd string) error {
+			return syscall.Mount("", procfd, "", uintptr(tmpfsFlags|syscall.MS_REMOUNT|syscall.MS_RDONLY), "mode=755")
+		})
+		if err != nil {
+			return fmt.Errorf("failed remount cgroup tmpfs read-only: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// parseCgroupFile parses /proc/<pid>/cgroup into a map of
+// controller list (e.g. "cpu,cpuacct" or "name=systemd") to cgroup path.
+// The cgroup v2 entry has an empty controller list.
+func parseCgroupFile(r io.Reader) (map[string]string, error) {
+	paths := map[string]string{}
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		// hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(s.Text(), ":", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid cgroup line: %s", s.Text())
+		}
+
+		paths[parts[1]] = parts[2]
+	}
+
+	return paths, s.Err()
+}
+
+func cgroupV1Binds(mounts []mountInfo, paths map[string]string) []cgroupV1Bind {
+	binds := []cgroupV1Bind{}
+	for _, m := range mounts {
+		if m.FSType != "cgroup" {
+			continue
+		}
+
+		superOptions := map[string]bool{}
+		for _, o := range strings.Split(m.SuperOptions, ",") {
+			superOptions[o] = true
+		}
+
+		for controllerList, path := range paths {
+			// the cgroup v2 entry
+			if controllerList == "" {
+				continue
+			}
+
+			controllers := strings.Split(controllerList, ",")
+			matched := true
+			for _, c := range controllers {
+				if !superOptions[c] {
+					matched = false
+					break
+				}
+			}
+			if !matched {
+				continue
+			}
+
+			rel, err := filepath.Rel(m.Root, path)
+			if err != nil || strings.HasPrefix(rel, "..") {
+				continue
+			}
+
+			binds = append(binds, cgroupV1Bind{
+				Source:      filepath.Join(m.Mountpoint, rel),
+				Name:        filepath.Base(m.Mountpoint),
+				Controllers: controllers,
+			})
+			break
+		}
+	}
+
+	return binds
+}
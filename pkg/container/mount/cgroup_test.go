@@ -0,0 +1,123 @@
+package mount
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const sampleCgroupV1MountInfo = `22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+30 22 0:26 / /sys/fs/cgroup ro,nosuid,nodev,noexec shared:9 - tmpfs tmpfs ro,mode=755
+31 30 0:27 / /sys/fs/cgroup/systemd rw,nosuid,nodev,noexec,relatime shared:10 - cgroup cgroup rw,xattr,name=systemd
+32 30 0:28 / /sys/fs/cgroup/cpu,cpuacct rw,nosuid,nodev,noexec,relatime shared:11 - cgroup cgroup rw,cpu,cpuacct
+33 30 0:29 / /sys/fs/cgroup/memory rw,nosuid,nodev,noexec,relatime shared:12 - cgroup cgroup rw,memory
+34 30 0:30 /nested /sys/fs/cgroup/pids rw,nosuid,nodev,noexec,relatime shared:13 - cgroup cgroup rw,pids
+`
+
+const sampleCgroupFile = `12:pids:/nested/noic/test
+4:memory:/noic/test
+3:cpu,cpuacct:/noic/test
+1:name=systemd:/system.slice/noic-test.scope
+0::/system.slice/noic-test.scope
+`
+
+func TestParseCgroupFile(t *testing.T) {
+	paths, err := parseCgroupFile(strings.NewReader(sampleCgroupFile))
+	assert.NoError(t, err)
+
+	assert.Equal(t, map[string]string{
+		"pids":         "/nested/noic/test",
+		"memory":       "/noic/test",
+		"cpu,cpuacct":  "/noic/test",
+		"name=systemd": "/system.slice/noic-test.scope",
+		"":             "/system.slice/noic-test.scope",
+	}, paths)
+}
+
+func TestCgroupV1Binds(t *testing.T) {
+	mounts, err := parseMountInfo(strings.NewReader(sampleCgroupV1MountInfo))
+	assert.NoError(t, err)
+
+	paths, err := parseCgroupFile(strings.NewReader(sampleCgroupFile))
+	assert.NoError(t, err)
+
+	assert.Equal(t, []cgroupV1Bind{
+		{
+			Source:      "/sys/fs/cgroup/systemd/system.slice/noic-test.scope",
+			Name:        "systemd",
+			Controllers: []string{"name=systemd"},
+		},
+		{
+			Source:      "/sys/fs/cgroup/cpu,cpuacct/noic/test",
+			Name:        "cpu,cpuacct",
+			Controllers: []string{"cpu", "cpuacct"},
+		},
+		{
+			Source:      "/sys/fs/cgroup/memory/noic/test",
+			Name:        "memory",
+			Controllers: []string{"memory"},
+		},
+		{
+			Source:      "/sys/fs/cgroup/pids/noic/test",
+			Name:        "pids",
+			Controllers: []string{"pids"},
+		},
+	}, cgroupV1Binds(mounts, paths))
+}
+
+func TestCgroupV2Source(t *testing.T) {
+	tests := []struct {
+		name      string
+		mountInfo string
+		paths     map[string]string
+		want      string
+		wantErr   bool
+	}{
+		{
+			name:      "unified",
+			mountInfo: "30 22 0:26 / /sys/fs/cgroup rw,nosuid,nodev,noexec,relatime shared:9 - cgroup2 cgroup2 rw\n",
+			paths:     map[string]string{"": "/system.slice/noic-test.scope"},
+			want:      "/sys/fs/cgroup/system.slice/noic-test.scope",
+		},
+		{
+			name:      "hybrid",
+			mountInfo: sampleCgroupV1MountInfo + "35 30 0:31 / /sys/fs/cgroup/unified rw,nosuid,nodev,noexec,relatime shared:14 - cgroup2 cgroup2 rw\n",
+			paths:     map[string]string{"memory": "/noic/test", "": "/noic/test"},
+			want:      "/sys/fs/cgroup/unified/noic/test",
+		},
+		{
+			name:      "mount of a nested cgroup",
+			mountInfo: "30 22 0:26 /nested /sys/fs/cgroup rw - cgroup2 cgroup2 rw\n",
+			paths:     map[string]string{"": "/nested/noic/test"},
+			want:      "/sys/fs/cgroup/noic/test",
+		},
+		{
+			name:      "outside of the mount",
+			mountInfo: "30 22 0:26 /nested /sys/fs/cgroup rw - cgroup2 cgroup2 rw\n",
+			paths:     map[string]string{"": "/other/noic/test"},
+			wantErr:   true,
+		},
+		{
+			name:      "no cgroup v2 entry",
+			mountInfo: sampleCgroupV1MountInfo,
+			paths:     map[string]string{"memory": "/noic/test"},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mounts, err := parseMountInfo(strings.NewReader(tt.mountInfo))
+			assert.NoError(t, err)
+
+			got, err := cgroupV2Source(mounts, tt.paths)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
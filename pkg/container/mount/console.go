@@ -9,7 +9,8 @@ func MountConsole(slavePath string) error {
 	m := syscall.Umask(0o000)
 	defer syscall.Umask(m)
 
-	f, err := os.Create("/dev/console")
+	// O_RDONLY so that an existing /dev/console can be reused on a read-only rootfs
+	f, err := os.OpenFile("/dev/console", os.O_RDONLY|os.O_CREATE, 0o666)
 	if err != nil && !os.IsExist(err) {
 		return err
 	}
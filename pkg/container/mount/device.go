@@ -1,6 +1,7 @@
 package mount
 
 import (
+	"errors"
 	"fmt"
 	"io/fs"
 	"os"
@@ -11,6 +12,52 @@ import (
 	"golang.org/x/sys/unix"
 )
 
+// devMount is the tmpfs mounted on /dev when the spec does not mount /dev itself.
+var devMount = specsgo.Mount{
+	Destination: "/dev",
+	Type:        "tmpfs",
+	Source:      "tmpfs",
+	Options:     []string{"nosuid", "strictatime", "mode=755", "size=65536k"},
+}
+
+// devSubMounts are mounted below /dev unless the spec provides them.
+var devSubMounts = []specsgo.Mount{
+	{
+		Destination: "/dev/pts",
+		Type:        "devpts",
+		Source:      "devpts",
+		Options:     []string{"nosuid", "noexec", "newinstance", "ptmxmode=0666", "mode=0620", "gid=5"},
+	},
+	{
+		Destination: "/dev/shm",
+		Type:        "tmpfs",
+		Source:      "shm",
+		Options:     []string{"nosuid", "noexec", "nodev", "mode=1777", "size=65536k"},
+	},
+}
+
+// devMounts returns the /dev mounts missing from mounts. The tmpfs for /dev
+// itself is returned separately, since it has to be mounted before the spec
+// mounts that are placed below it.
+func devMounts(mounts []specsgo.Mount) (dev []specsgo.Mount, sub []specsgo.Mount) {
+	mounted := map[string]bool{}
+	for _, m := range mounts {
+		mounted[filepath.Clean(m.Destination)] = true
+	}
+
+	if !mounted[devMount.Destination] {
+		dev = append(dev, devMount)
+	}
+
+	for _, m := range devSubMounts {
+		if !mounted[m.Destination] {
+			sub = append(sub, m)
+		}
+	}
+
+	return dev, sub
+}
+
 // https://github.com/opencontainers/runtime-spec/blob/494a5a6aca782455c0fbfc35af8e12f04e98a55e/config-linux.md#default-devices
 func createDefaultDevices(rootfsPath string) error {
 	uid := uint32(0)
@@ -84,37 +131,118 @@ func createDefaultDevices(rootfsPath string) error {
 	return createDevices(defaultDevices, rootfsPath)
 }
 
-func createDevices(devices []specsgo.LinuxDevice, path string) error {
+// createDevices creates the device nodes inside rootfs. An existing node of
+// the same type and number is left as it is, anything else in its place is
+// replaced. When mknod is not permitted, as in a user namespace, the device
+// of the host is bind mounted instead.
+func createDevices(devices []specsgo.LinuxDevice, rootfs string) error {
+	oldMask := syscall.Umask(0000)
+	defer syscall.Umask(oldMask)
+
 	for _, device := range devices {
-		dest := filepath.Join(path, device.Path)
-		if err := mknodDevice(dest, device); err != nil {
-			return fmt.Errorf("faild mknod: %s", err)
+		err := createDevice(rootfs, device)
+		switch {
+		case err == nil:
+		case errors.Is(err, os.ErrPermission):
+			if err := bindMount(device.Path, rootfs, device.Path, mountOptions{flags: syscall.MS_BIND}); err != nil {
+				return fmt.Errorf("failed bind mount device %s: %s", device.Path, err)
+			}
+		default:
+			return fmt.Errorf("failed mknod %s: %s", device.Path, err)
 		}
 	}
 
 	return nil
 }
 
-func mknodDevice(dest string, device specsgo.LinuxDevice) error {
-	fileMode := *device.FileMode
-	deviceType := device.Type
-	switch deviceType {
-	case "c":
-		fileMode |= unix.S_IFCHR
+// createDevice creates the node of device inside rootfs. The parents are
+// created with mkdirAllInRoot and the node relative to the parent, so that
+// a symlink in the path cannot redirect it out of rootfs.
+func createDevice(rootfs string, device specsgo.LinuxDevice) error {
+	path := filepath.Join("/", device.Path)
+	dir, err := mkdirAllInRoot(rootfs, filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	name := filepath.Base(path)
+	err = mknodDevice(dir, name, device)
+	if !errors.Is(err, os.ErrExist) {
+		return err
+	}
+
+	var stat unix.Stat_t
+	if err := unix.Fstatat(int(dir.Fd()), name, &stat, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return &os.PathError{Op: "fstatat", Path: device.Path, Err: err}
+	}
+
+	mode, err := deviceMode(device)
+	if err != nil {
+		return err
+	}
+	if stat.Mode&unix.S_IFMT == mode&unix.S_IFMT && (device.Type == "p" || stat.Rdev == deviceNumber(device)) {
+		return nil
+	}
+
+	// e.g. a regular file or a node of another device shipped in the image
+	if err := unix.Unlinkat(int(dir.Fd()), name, 0); err != nil {
+		return fmt.Errorf("failed replace %s: %w", device.Path, &os.PathError{Op: "unlinkat", Path: device.Path, Err: err})
+	}
+
+	return mknodDevice(dir, name, device)
+}
+
+// mknodDevice creates the node of device as name in dir.
+func mknodDevice(dir *os.File, name string, device specsgo.LinuxDevice) error {
+	mode, err := deviceMode(device)
+	if err != nil {
+		return err
+	}
+
+	var uid, gid uint32
+	if device.UID != nil {
+		uid = *device.UID
+	}
+	if device.GID != nil {
+		gid = *device.GID
+	}
+
+	if err := unix.Mknodat(int(dir.Fd()), name, mode, int(deviceNumber(device))); err != nil {
+		return &os.PathError{Op: "mknodat", Path: device.Path, Err: err}
+	}
+
+	if err := unix.Fchownat(int(dir.Fd()), name, int(uid), int(gid), unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return &os.PathError{Op: "fchownat", Path: device.Path, Err: err}
+	}
+
+	return nil
+}
+
+// deviceMode returns the mode of the node of device, including the file type.
+func deviceMode(device specsgo.LinuxDevice) (uint32, error) {
+	fileMode := fs.FileMode(0o666)
+	if device.FileMode != nil {
+		fileMode = *device.FileMode
+	}
+
+	mode := uint32(fileMode.Perm())
+	switch device.Type {
+	case "c", "u":
+		mode |= unix.S_IFCHR
 	case "b":
-		fileMode |= unix.S_IFBLK
+		mode |= unix.S_IFBLK
 	case "p":
-		fileMode |= unix.S_IFIFO
+		mode |= unix.S_IFIFO
 	default:
-		return fmt.Errorf("invalid device type: %s", deviceType)
+		return 0, fmt.Errorf("invalid device type: %s", device.Type)
 	}
 
-	d := unix.Mkdev(uint32(device.Major), uint32(device.Minor))
-	if err := syscall.Mknod(dest, uint32(fileMode), int(d)); err != nil {
-		return err
-	}
+	return mode, nil
+}
 
-	return os.Chown(dest, int(*device.UID), int(*device.GID))
+func deviceNumber(device specsgo.LinuxDevice) uint64 {
+	return unix.Mkdev(uint32(device.Major), uint32(device.Minor))
 }
 
 func createDevSymlinks(path string) error {
@@ -126,7 +254,12 @@ func createDevSymlinks(path string) error {
 	}
 
 	for _, link := range links {
-		if err := os.Symlink(link[0], filepath.Join(path, link[1])); err != nil {
+		dest, err := secureJoinNoFollow(path, link[1])
+		if err != nil {
+			return err
+		}
+
+		if err := os.Symlink(link[0], dest); err != nil && !errors.Is(err, os.ErrExist) {
 			return err
 		}
 	}
@@ -134,10 +267,29 @@ func createDevSymlinks(path string) error {
 	return nil
 }
 
+// setupPtmx points /dev/ptmx at the ptmx of the devpts instance of the container.
+// An existing /dev/ptmx is replaced, since it would refer to the devpts of the host.
 func setupPtmx(path string) error {
-	ptmx := filepath.Join(path, "dev/ptmx")
-	if err := os.Symlink("pts/ptmx", ptmx); err != nil {
+	ptmx, err := secureJoinNoFollow(path, "/dev/ptmx")
+	if err != nil {
 		return err
 	}
-	return nil
+
+	if err := os.Remove(ptmx); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return os.Symlink("pts/ptmx", ptmx)
+}
+
+// secureJoinNoFollow is like SecureJoin, but does not resolve the last component
+// of unsafePath, so that an existing symlink is not followed.
+func secureJoinNoFollow(root, unsafePath string) (string, error) {
+	unsafePath = filepath.Join("/", unsafePath)
+	dir, err := SecureJoin(root, filepath.Dir(unsafePath))
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, filepath.Base(unsafePath)), nil
 }
@@ -0,0 +1,176 @@
+package mount
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDevMounts(t *testing.T) {
+	tests := []struct {
+		name    string
+		mounts  []specs.Mount
+		wantDev []string
+		wantSub []string
+	}{
+		{
+			name:    "nothing mounted",
+			mounts:  nil,
+			wantDev: []string{"/dev"},
+			wantSub: []string{"/dev/pts", "/dev/shm"},
+		},
+		{
+			name:    "dev mounted from the spec",
+			mounts:  []specs.Mount{{Destination: "/dev/"}},
+			wantDev: nil,
+			wantSub: []string{"/dev/pts", "/dev/shm"},
+		},
+		{
+			name:    "everything mounted from the spec",
+			mounts:  []specs.Mount{{Destination: "/dev"}, {Destination: "/dev/pts"}, {Destination: "/dev/shm"}},
+			wantDev: nil,
+			wantSub: nil,
+		},
+	}
+
+	destinations := func(mounts []specs.Mount) []string {
+		var d []string
+		for _, m := range mounts {
+			d = append(d, m.Destination)
+		}
+		return d
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dev, sub := devMounts(tt.mounts)
+			assert.Equal(t, tt.wantDev, destinations(dev))
+			assert.Equal(t, tt.wantSub, destinations(sub))
+		})
+	}
+}
+
+func TestCreateDevices(t *testing.T) {
+	unshareMountNamespace(t)
+
+	rootfs := t.TempDir()
+	devices := []specs.LinuxDevice{
+		{Path: "/dev/null", Type: "c", Major: 1, Minor: 3},
+		{Path: "/dev/net/tun", Type: "c", Major: 10, Minor: 200},
+	}
+	defer syscall.Unmount(filepath.Join(rootfs, "dev/null"), syscall.MNT_DETACH)
+	defer syscall.Unmount(filepath.Join(rootfs, "dev/net/tun"), syscall.MNT_DETACH)
+
+	require.NoError(t, createDevices(devices, rootfs))
+	// existing nodes are tolerated
+	require.NoError(t, createDevices(devices, rootfs))
+
+	fi, err := os.Stat(filepath.Join(rootfs, "dev/null"))
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeDevice|os.ModeCharDevice|0o666, fi.Mode())
+	assert.EqualValues(t, 0, fi.Sys().(*syscall.Stat_t).Uid)
+}
+
+func TestCreateDevices_Existing(t *testing.T) {
+	unshareMountNamespace(t)
+
+	null := specs.LinuxDevice{Path: "/dev/null", Type: "c", Major: 1, Minor: 3}
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T, path string)
+		wantErr bool
+	}{
+		{
+			name: "same device",
+			setup: func(t *testing.T, path string) {
+				require.NoError(t, syscall.Mknod(path, syscall.S_IFCHR|0o600, int(deviceNumber(null))))
+			},
+		},
+		{
+			name: "other device",
+			setup: func(t *testing.T, path string) {
+				require.NoError(t, syscall.Mknod(path, syscall.S_IFCHR|0o666, int(deviceNumber(specs.LinuxDevice{Major: 1, Minor: 5}))))
+			},
+		},
+		{
+			name: "regular file",
+			setup: func(t *testing.T, path string) {
+				require.NoError(t, os.WriteFile(path, []byte("not a device"), 0o644))
+			},
+		},
+		{
+			name: "symlink",
+			setup: func(t *testing.T, path string) {
+				require.NoError(t, os.Symlink("/etc/passwd", path))
+			},
+		},
+		{
+			name: "directory",
+			setup: func(t *testing.T, path string) {
+				require.NoError(t, os.Mkdir(path, 0o755))
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rootfs := t.TempDir()
+			path := filepath.Join(rootfs, "dev/null")
+			require.NoError(t, os.Mkdir(filepath.Join(rootfs, "dev"), 0o755))
+			tt.setup(t, path)
+			defer syscall.Unmount(path, syscall.MNT_DETACH)
+
+			err := createDevices([]specs.LinuxDevice{null}, rootfs)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			fi, err := os.Lstat(path)
+			require.NoError(t, err)
+			assert.Equal(t, os.ModeDevice|os.ModeCharDevice, fi.Mode().Type())
+			assert.Equal(t, deviceNumber(null), fi.Sys().(*syscall.Stat_t).Rdev)
+		})
+	}
+}
+
+func TestCreateDevices_SymlinkParent(t *testing.T) {
+	unshareMountNamespace(t)
+
+	rootfs := t.TempDir()
+	outside := t.TempDir()
+	require.NoError(t, os.Symlink(outside, filepath.Join(rootfs, "dev")))
+	defer syscall.Unmount(filepath.Join(rootfs, "dev/null"), syscall.MNT_DETACH)
+
+	require.NoError(t, createDevices([]specs.LinuxDevice{{Path: "/dev/null", Type: "c", Major: 1, Minor: 3}}, rootfs))
+
+	_, err := os.Lstat(filepath.Join(outside, "null"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCreateDefaultDevices_Existing(t *testing.T) {
+	unshareMountNamespace(t)
+
+	rootfs := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(rootfs, "dev"), 0o755))
+	require.NoError(t, os.Symlink("/proc/self/fd", filepath.Join(rootfs, "dev/fd")))
+	require.NoError(t, os.WriteFile(filepath.Join(rootfs, "dev/ptmx"), nil, 0o644))
+	defer func() {
+		for _, d := range []string{"null", "random", "full", "tty", "zero", "urandom"} {
+			syscall.Unmount(filepath.Join(rootfs, "dev", d), syscall.MNT_DETACH)
+		}
+	}()
+
+	require.NoError(t, createDefaultDevices(rootfs))
+
+	target, err := os.Readlink(filepath.Join(rootfs, "dev/ptmx"))
+	require.NoError(t, err)
+	assert.Equal(t, "pts/ptmx", target)
+}
@@ -0,0 +1,96 @@
+package mount
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+// NewIDMapUserns returns a user namespace with the given mappings, to be used for an idmapped mount.
+// It must be called from a process that can see the helper it spawns in /proc,
+// i.e. before entering the container's pid namespace.
+func NewIDMapUserns(uidMappings, gidMappings []specs.LinuxIDMapping) (*os.File, error) {
+	// ptrace requires the tracee to be waited on from the same thread
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	// The helper process only has to exist with the mappings, so it is
+	// stopped by PTRACE_TRACEME right after execve and never runs.
+	proc, err := os.StartProcess("/proc/self/exe", []string{"noic", "--help"}, &os.ProcAttr{
+		Sys: &syscall.SysProcAttr{
+			Cloneflags:                 syscall.CLONE_NEWUSER,
+			UidMappings:                toSysProcIDMap(uidMappings),
+			GidMappings:                toSysProcIDMap(gidMappings),
+			GidMappingsEnableSetgroups: false,
+			Ptrace:                     true,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed spawn process for idmapped mount: %s", err)
+	}
+	defer func() {
+		_ = proc.Kill()
+		_, _ = proc.Wait()
+	}()
+
+	return os.Open(fmt.Sprintf("/proc/%d/ns/user", proc.Pid))
+}
+
+func toSysProcIDMap(mappings []specs.LinuxIDMapping) []syscall.SysProcIDMap {
+	m := make([]syscall.SysProcIDMap, 0, len(mappings))
+	for _, mapping := range mappings {
+		m = append(m, syscall.SysProcIDMap{
+			ContainerID: int(mapping.ContainerID),
+			HostID:      int(mapping.HostID),
+			Size:        int(mapping.Size),
+		})
+	}
+
+	return m
+}
+
+// idmappedBindMount clones source with open_tree(2), idmaps it with the
+// mappings of userns and moves it onto destination inside rootfs.
+func idmappedBindMount(source, rootfs, destination string, opts mountOptions, userns *os.File) error {
+	stat, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+
+	if err := createInRoot(rootfs, destination, stat.IsDir()); err != nil {
+		return err
+	}
+
+	// OPEN_TREE_CLOEXEC is O_CLOEXEC
+	treeFlags := uint(unix.OPEN_TREE_CLONE | unix.O_CLOEXEC)
+	attrFlags := uint(unix.AT_EMPTY_PATH)
+	if opts.flags&syscall.MS_REC != 0 {
+		treeFlags |= unix.AT_RECURSIVE
+		attrFlags |= unix.AT_RECURSIVE
+	}
+
+	fd, err := unix.OpenTree(unix.AT_FDCWD, source, treeFlags)
+	if err != nil {
+		return &os.PathError{Op: "open_tree", Path: source, Err: err}
+	}
+	defer unix.Close(fd)
+
+	attr := &unix.MountAttr{Attr_set: unix.MOUNT_ATTR_IDMAP, Userns_fd: uint64(userns.Fd())}
+	if err := unix.MountSetattr(fd, "", attrFlags, attr); err != nil {
+		return &os.PathError{Op: "mount_setattr", Path: source, Err: err}
+	}
+
+	err = withProcfd(rootfs, destination, func(procfd string) error {
+		// move_mount(2) does not follow the procfd magic link without MOVE_MOUNT_T_SYMLINKS
+		return unix.MoveMount(fd, "", unix.AT_FDCWD, procfd, unix.MOVE_MOUNT_F_EMPTY_PATH|unix.MOVE_MOUNT_T_SYMLINKS)
+	})
+	if err != nil {
+		return &os.PathError{Op: "move_mount", Path: destination, Err: err}
+	}
+
+	return applyBindFlags(rootfs, destination, opts)
+}
@@ -1,25 +1,104 @@
 package mount
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"syscall"
+
+	"golang.org/x/sys/unix"
 )
 
-func MaskPath(path string) error {
-	fileInfo, err := os.Stat(path)
+// MaskPaths hides paths inside root from the container. Directories are
+// covered by an empty read-only tmpfs, which is mounted once and shared by
+// every masked directory, and anything else by a bind mount of /dev/null.
+// Paths that do not exist are skipped. Symlinks are never followed, so a
+// masked path containing one is an error.
+func MaskPaths(root string, paths []string) error {
+	var maskDir string
+	for _, path := range paths {
+		f, err := openNoFollow(root, path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if errors.Is(err, unix.ELOOP) {
+			return fmt.Errorf("refusing to mask %s: path contains a symlink", path)
+		}
+		if err != nil {
+			return fmt.Errorf("failed mask %s: %w", path, err)
+		}
+
+		err = maskPath(f, &maskDir)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed mask %s: %w", path, err)
+		}
+	}
+
+	return nil
+}
+
+// maskPath mounts over the file opened as f. maskDir holds the first directory
+// masked with the shared tmpfs, and is set when the tmpfs is mounted.
+func maskPath(f *os.File, maskDir *string) error {
+	fi, err := f.Stat()
 	if err != nil {
-		return nil
-		// return syscall.Mount("/dev/null", path, "", syscall.MS_BIND, "")
+		return err
+	}
+
+	procfd := fmt.Sprintf("/proc/self/fd/%d", f.Fd())
+	if fi.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("refusing to mask symlink %s", f.Name())
 	}
 
-	if fileInfo.IsDir() {
-		return syscall.Mount("tmpfs", path, "tmpfs", syscall.MS_RDONLY, "")
+	if !fi.IsDir() {
+		return syscall.Mount("/dev/null", procfd, "", syscall.MS_BIND, "")
 	}
 
-	if err := syscall.Mount("/dev/null", path, "", syscall.MS_BIND, ""); err != nil {
-		return fmt.Errorf("mount failed: %s(%s), %s", path, fileInfo, err)
+	if *maskDir != "" {
+		return syscall.Mount(*maskDir, procfd, "", syscall.MS_BIND, "")
 	}
 
+	if err := syscall.Mount("tmpfs", procfd, "tmpfs", syscall.MS_RDONLY|syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, ""); err != nil {
+		return err
+	}
+
+	*maskDir = f.Name()
 	return nil
 }
+
+// openNoFollow opens unsafePath inside root with O_PATH, failing with ELOOP
+// if any component of the path is a symlink.
+func openNoFollow(root, unsafePath string) (*os.File, error) {
+	path := filepath.Join(root, filepath.Join("/", unsafePath))
+	fd, err := unix.Openat2(unix.AT_FDCWD, path, &unix.OpenHow{
+		Flags:   unix.O_PATH | unix.O_NOFOLLOW | unix.O_CLOEXEC,
+		Resolve: unix.RESOLVE_NO_SYMLINKS,
+	})
+	if err == nil {
+		return os.NewFile(uintptr(fd), path), nil
+	}
+	if !errors.Is(err, unix.ENOSYS) {
+		return nil, &os.PathError{Op: "openat2", Path: path, Err: err}
+	}
+
+	// openat2(2) is not available before Linux 5.6
+	fd, err = unix.Open(path, unix.O_PATH|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	f := os.NewFile(uintptr(fd), path)
+
+	realpath, err := os.Readlink(fmt.Sprintf("/proc/self/fd/%d", fd))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if realpath != path {
+		f.Close()
+		return nil, &os.PathError{Op: "open", Path: path, Err: unix.ELOOP}
+	}
+
+	return f, nil
+}
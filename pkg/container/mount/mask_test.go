@@ -0,0 +1,65 @@
+package mount
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestMaskPaths_Proc(t *testing.T) {
+	unshareMountNamespace(t)
+
+	root := t.TempDir()
+	proc := filepath.Join(root, "proc")
+	require.NoError(t, os.Mkdir(proc, 0o755))
+	require.NoError(t, unix.Mount("proc", proc, "proc", 0, ""))
+	defer unix.Unmount(proc, unix.MNT_DETACH)
+
+	masked := []string{"/proc/kcore", "/proc/keys", "/proc/timer_list", "/proc/acpi", "/proc/scsi", "/proc/nonexistent"}
+	require.NoError(t, MaskPaths(root, masked))
+
+	for _, path := range []string{"/proc/kcore", "/proc/keys", "/proc/timer_list"} {
+		b, err := os.ReadFile(filepath.Join(root, path))
+		if os.IsNotExist(err) {
+			continue
+		}
+		require.NoError(t, err, path)
+		assert.Empty(t, b, path)
+	}
+
+	for _, path := range []string{"/proc/acpi", "/proc/scsi"} {
+		dir := filepath.Join(root, path)
+		entries, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		require.NoError(t, err, path)
+		assert.Empty(t, entries, path)
+
+		err = os.WriteFile(filepath.Join(dir, "file"), nil, 0o644)
+		assert.ErrorIs(t, err, syscall.EROFS, path)
+	}
+}
+
+func TestMaskPaths_Symlink(t *testing.T) {
+	unshareMountNamespace(t)
+
+	root := t.TempDir()
+	secret := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(secret, []byte("secret"), 0o644))
+	require.NoError(t, os.Symlink(secret, filepath.Join(root, "link")))
+	require.NoError(t, os.Symlink(filepath.Dir(secret), filepath.Join(root, "dir")))
+
+	assert.Error(t, MaskPaths(root, []string{"/link"}))
+	assert.Error(t, MaskPaths(root, []string{"/dir/secret"}))
+
+	// nothing outside of the root was mounted over
+	b, err := os.ReadFile(secret)
+	require.NoError(t, err)
+	assert.Equal(t, "secret", string(b))
+}
@@ -1,115 +1,147 @@
 package mount
 
 import (
+	"errors"
 	"fmt"
 	"os"
-	"path"
-	"path/filepath"
-	"strings"
 	"syscall"
 
-	"github.com/mrtc0/noic/pkg/container/cgroups"
+	"github.com/mrtc0/noic/pkg/container/selinux"
 	"github.com/opencontainers/runtime-spec/specs-go"
 )
 
-var (
-	mountFlags = map[string]int{
-		"nosuid":      syscall.MS_NOSUID,
-		"nodev":       syscall.MS_NODEV,
-		"noexec":      syscall.MS_NOEXEC,
-		"strictatime": syscall.MS_STRICTATIME,
-		"relatime":    syscall.MS_RELATIME,
-		"ro":          syscall.MS_RDONLY,
-		"bind":        syscall.MS_BIND,
-		"rbind":       syscall.MS_BIND | syscall.MS_REC,
-		"rprivate":    syscall.MS_PRIVATE | syscall.MS_REC,
-	}
-)
-
-func MountFilesystems(rootfs string, mounts []specs.Mount) error {
-	for _, mnt := range mounts {
+// MountFilesystems mounts spec mounts into rootfs. idmapUserns holds the user
+// namespaces for idmapped mounts, keyed by the index of the mount.
+func MountFilesystems(rootfs string, mounts []specs.Mount, mountLabel string, idmapUserns map[int]*os.File) error {
+	for i, mnt := range mounts {
 		if mnt.Destination == "" {
 			return fmt.Errorf("invalid destination of mount point")
 		}
 
-		var flags int
-		var labels []string
-		for _, option := range mnt.Options {
-			if flag, exists := mountFlags[option]; exists {
-				flags |= flag
-			} else {
-				labels = append(labels, option)
-			}
+		opts := parseMountOptions(mnt.Options)
+		isBind := mnt.Type == "bind" || opts.flags&syscall.MS_BIND != 0
+		isIdmapped := len(mnt.UIDMappings) > 0 || len(mnt.GIDMappings) > 0
+		if isIdmapped && !isBind {
+			return fmt.Errorf("idmapped mounts are only supported for bind mounts: %s", mnt.Destination)
 		}
 
-		dest := path.Join(rootfs, mnt.Destination)
-		switch mnt.Type {
-		case "cgroup":
-			if cgroups.IsVersion2() {
-				return mountCgroupV2(mnt.Source, dest, flags, labels)
+		// a plain bind mount in place of the idmapped one would expose the
+		// files with the ownership of the host
+		if _, ok := idmapUserns[i]; isIdmapped && !ok {
+			return fmt.Errorf("no user namespace for the idmapped mount %s", mnt.Destination)
+		}
+
+		switch {
+		case mnt.Type == "cgroup":
+			if err := mountCgroup(rootfs, mnt.Destination, opts); err != nil {
+				return fmt.Errorf("failed mount cgroup: %s", err)
 			}
-		case "bind":
-			if err := bindMount(mnt.Source, dest, uintptr(flags), strings.Join(labels, ",")); err != nil {
+		case isBind:
+			if userns, ok := idmapUserns[i]; ok {
+				if err := idmappedBindMount(mnt.Source, rootfs, mnt.Destination, opts, userns); err != nil {
+					return fmt.Errorf("failed idmapped bind mount %s: %s", mnt.Source, err)
+				}
+				break
+			}
+
+			if err := bindMount(mnt.Source, rootfs, mnt.Destination, opts); err != nil {
 				return fmt.Errorf("failed bind mount %s: %s", mnt.Source, err)
 			}
 		default:
-			if err := os.MkdirAll(dest, 0o755); err != nil {
-				return fmt.Errorf("failed create directory: %s", mnt.Destination)
+			if err := createInRoot(rootfs, mnt.Destination, true); err != nil {
+				return fmt.Errorf("failed create directory %s: %s", mnt.Destination, err)
 			}
 
-			if err := syscall.Mount(mnt.Source, dest, mnt.Type, uintptr(flags), strings.Join(labels, ",")); err != nil {
+			data := opts.mountData()
+			switch mnt.Type {
+			case "tmpfs", "mqueue", "devpts":
+				data = selinux.FormatMountLabel(data, mountLabel)
+			}
+
+			err := withProcfd(rootfs, mnt.Destination, func(procfd string) error {
+				return syscall.Mount(mnt.Source, procfd, mnt.Type, uintptr(opts.flags), data)
+			})
+			if err != nil {
 				return fmt.Errorf("failed mount. source: %s, destination: %s, type: %s, %v", mnt.Source, mnt.Destination, mnt.Type, err)
 			}
 		}
+
+		if err := applyPropagationAndAttributes(rootfs, mnt.Destination, opts); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func bindMount(source, destination string, flags uintptr, data string) error {
+// bindMount bind mounts source onto destination, which is resolved inside rootfs.
+func bindMount(source, rootfs, destination string, opts mountOptions) error {
 	stat, err := os.Stat(source)
 	if err != nil {
 		return err
 	}
 
-	if err := createFileOrDirectory(destination, stat.IsDir()); err != nil {
+	if err := createInRoot(rootfs, destination, stat.IsDir()); err != nil {
 		return err
 	}
 
-	if err := syscall.Mount(source, destination, "bind", flags, data); err != nil {
+	bindFlags := opts.flags & (syscall.MS_BIND | syscall.MS_REC)
+	err = withProcfd(rootfs, destination, func(procfd string) error {
+		return syscall.Mount(source, procfd, "bind", uintptr(syscall.MS_BIND|bindFlags), opts.mountData())
+	})
+	if err != nil {
 		return fmt.Errorf("failed mount. source: %s, destination: %s, type: bind, %v", source, destination, err)
 	}
 
-	return nil
+	return applyBindFlags(rootfs, destination, opts)
 }
 
-func createFileOrDirectory(path string, isDir bool) error {
-	if _, err := os.Stat(path); err != nil {
-		if os.IsNotExist(err) {
-			if isDir {
-				return os.MkdirAll(path, 0o755)
-			}
-			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
-				return err
-			}
+// applyBindFlags remounts the bind mount at destination with the requested flags.
+// The kernel ignores every flag but MS_BIND and MS_REC when creating a bind
+// mount, so the rest have to be applied with a remount.
+func applyBindFlags(rootfs, destination string, opts mountOptions) error {
+	if opts.flags&^(syscall.MS_BIND|syscall.MS_REC|syscall.MS_REMOUNT) == 0 {
+		return nil
+	}
 
-			f, err := os.OpenFile(path, os.O_CREATE, 0o755)
-			if err != nil {
-				return err
-			}
+	// the destination is opened again so that the handle refers to the new mount
+	return withProcfd(rootfs, destination, func(procfd string) error {
+		return remountBind(procfd, opts.flags, opts.clearedFlags)
+	})
+}
 
-			f.Close()
-		}
+// remountBind applies flags to the bind mount at path. Flags of the
+// underlying mount that were not cleared explicitly are preserved, since
+// locked flags cannot be removed in a user namespace.
+func remountBind(path string, flags, clearedFlags int) error {
+	var s syscall.Statfs_t
+	if err := syscall.Statfs(path, &s); err != nil {
+		return &os.PathError{Op: "statfs", Path: path, Err: err}
+	}
+
+	preserved := int(s.Flags) & (syscall.MS_RDONLY | syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC) &^ clearedFlags
+	flags = (flags | preserved) &^ (syscall.MS_REC | syscall.MS_REMOUNT | syscall.MS_BIND)
+
+	if err := syscall.Mount("", path, "", uintptr(flags|syscall.MS_BIND|syscall.MS_REMOUNT), ""); err != nil {
+		return fmt.Errorf("failed remount bind mount %s: %v", path, err)
 	}
 
 	return nil
 }
 
-func mountCgroupV2(source string, destination string, flags int, labels []string) error {
-	if err := os.MkdirAll(destination, 0o755); err != nil {
-		return err
+func MountReadonlyPaths(paths []string) error {
+	for _, path := range paths {
+		if err := syscall.Mount(path, path, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return err
+		}
+
+		if err := remountBind(path, syscall.MS_RDONLY, 0); err != nil {
+			return err
+		}
 	}
 
-	err := syscall.Mount(source, destination, "cgroup2", uintptr(flags), strings.Join(labels, ","))
-	return err
+	return nil
 }
@@ -0,0 +1,170 @@
+package mount
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// unshareMountNamespace moves the test goroutine into a private mount
+// namespace. The OS thread is never unlocked, so it is thrown away with
+// the namespace when the test finishes.
+func unshareMountNamespace(t *testing.T) {
+	t.Helper()
+
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+
+	runtime.LockOSThread()
+	if err := unix.Unshare(unix.CLONE_NEWNS | unix.CLONE_FS); err != nil {
+		t.Skipf("unshare mount namespace: %s", err)
+	}
+
+	require.NoError(t, unix.Mount("", "/", "", unix.MS_PRIVATE|unix.MS_REC, ""))
+}
+
+func TestBindMount_Readonly(t *testing.T) {
+	unshareMountNamespace(t)
+
+	source := t.TempDir()
+	destination := filepath.Join(t.TempDir(), "dest")
+	defer unix.Unmount(destination, unix.MNT_DETACH)
+
+	err := bindMount(source, "/", destination, parseMountOptions([]string{"rbind", "ro", "nosuid"}))
+	require.NoError(t, err)
+
+	err = os.WriteFile(filepath.Join(destination, "file"), []byte("test"), 0o644)
+	assert.ErrorIs(t, err, syscall.EROFS)
+
+	var s unix.Statfs_t
+	require.NoError(t, unix.Statfs(destination, &s))
+	assert.NotZero(t, s.Flags&unix.ST_RDONLY)
+	assert.NotZero(t, s.Flags&unix.ST_NOSUID)
+
+	// the source stays writable
+	assert.NoError(t, os.WriteFile(filepath.Join(source, "file"), []byte("test"), 0o644))
+}
+
+func TestBindMount_File(t *testing.T) {
+	unshareMountNamespace(t)
+
+	source := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(source, []byte("test"), 0o644))
+	destination := filepath.Join(t.TempDir(), "dir", "file")
+	defer unix.Unmount(destination, unix.MNT_DETACH)
+
+	err := bindMount(source, "/", destination, parseMountOptions([]string{"bind", "ro"}))
+	require.NoError(t, err)
+
+	b, err := os.ReadFile(destination)
+	assert.NoError(t, err)
+	assert.Equal(t, "test", string(b))
+
+	err = os.WriteFile(destination, []byte("changed"), 0o644)
+	assert.ErrorIs(t, err, syscall.EROFS)
+}
+
+func TestBindMount_Writable(t *testing.T) {
+	unshareMountNamespace(t)
+
+	source := t.TempDir()
+	destination := filepath.Join(t.TempDir(), "dest")
+	defer unix.Unmount(destination, unix.MNT_DETACH)
+
+	err := bindMount(source, "/", destination, parseMountOptions([]string{"rbind", "rw"}))
+	require.NoError(t, err)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(destination, "file"), []byte("test"), 0o644))
+}
+
+func TestMountReadonlyPaths(t *testing.T) {
+	unshareMountNamespace(t)
+
+	dir := t.TempDir()
+	defer unix.Unmount(dir, unix.MNT_DETACH)
+
+	err := MountReadonlyPaths([]string{dir, filepath.Join(dir, "does-not-exist")})
+	require.NoError(t, err)
+
+	err = os.WriteFile(filepath.Join(dir, "file"), []byte("test"), 0o644)
+	assert.ErrorIs(t, err, syscall.EROFS)
+}
+
+func TestBindMount_SymlinkDestination(t *testing.T) {
+	unshareMountNamespace(t)
+
+	rootfs := t.TempDir()
+	host := t.TempDir()
+	source := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(source, "file"), []byte("test"), 0o644))
+
+	// a malicious image pointing a mount destination at the host
+	require.NoError(t, os.Symlink(host, filepath.Join(rootfs, "evil")))
+	defer unix.Unmount(filepath.Join(rootfs, host), unix.MNT_DETACH)
+
+	err := bindMount(source, rootfs, "/evil", parseMountOptions([]string{"rbind"}))
+	require.NoError(t, err)
+
+	_, err = os.Stat(filepath.Join(host, "file"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	_, err = os.Stat(filepath.Join(rootfs, host, "file"))
+	assert.NoError(t, err)
+}
+
+func TestIDMappedBindMount(t *testing.T) {
+	unshareMountNamespace(t)
+
+	source := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(source, "file"), []byte("test"), 0o644))
+	require.NoError(t, os.Chown(filepath.Join(source, "file"), 0, 0))
+	destination := filepath.Join(t.TempDir(), "dest")
+	defer unix.Unmount(destination, unix.MNT_DETACH)
+
+	mapping := []specs.LinuxIDMapping{{ContainerID: 0, HostID: 100000, Size: 65536}}
+	userns, err := NewIDMapUserns(mapping, mapping)
+	require.NoError(t, err)
+	defer userns.Close()
+
+	err = idmappedBindMount(source, "/", destination, parseMountOptions([]string{"rbind", "ro"}), userns)
+	if errors.Is(err, unix.EINVAL) {
+		t.Skipf("filesystem does not support idmapped mounts: %s", err)
+	}
+	require.NoError(t, err)
+
+	var s unix.Stat_t
+	require.NoError(t, unix.Stat(filepath.Join(destination, "file"), &s))
+	assert.Equal(t, uint32(100000), s.Uid)
+	assert.Equal(t, uint32(100000), s.Gid)
+
+	err = os.WriteFile(filepath.Join(destination, "file"), []byte("changed"), 0o644)
+	assert.ErrorIs(t, err, syscall.EROFS)
+}
+
+func TestMountFilesystems_IDMappedWithoutUserns(t *testing.T) {
+	rootfs := t.TempDir()
+	mounts := []specs.Mount{
+		{
+			Destination: "/data",
+			Type:        "bind",
+			Source:      t.TempDir(),
+			Options:     []string{"rbind"},
+			UIDMappings: []specs.LinuxIDMapping{{ContainerID: 0, HostID: 100000, Size: 65536}},
+		},
+	}
+
+	err := MountFilesystems(rootfs, mounts, "", nil)
+	assert.Error(t, err)
+
+	_, err = os.Lstat(filepath.Join(rootfs, "data"))
+	assert.True(t, os.IsNotExist(err))
+}
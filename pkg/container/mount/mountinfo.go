@@ -0,0 +1,108 @@
+package mount
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type mountInfo struct {
+	Root       string
+	Mountpoint string
+	// optional fields, e.g. "shared:1 master:2"
+	Optional     string
+	FSType       string
+	SuperOptions string
+}
+
+func readMountInfo() ([]mountInfo, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseMountInfo(f)
+}
+
+// https://man7.org/linux/man-pages/man5/proc.5.html /proc/[pid]/mountinfo
+func parseMountInfo(r io.Reader) ([]mountInfo, error) {
+	mounts := []mountInfo{}
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 7 {
+			return nil, fmt.Errorf("invalid mountinfo line: %s", s.Text())
+		}
+
+		var optional []string
+		i := 6
+		for ; i < len(fields) && fields[i] != "-"; i++ {
+			optional = append(optional, fields[i])
+		}
+
+		m := mountInfo{
+			Root:       unescapeMountInfo(fields[3]),
+			Mountpoint: unescapeMountInfo(fields[4]),
+			Optional:   strings.Join(optional, " "),
+		}
+
+		// fstype, mount source and super options follow the separator
+		if i+3 < len(fields) {
+			m.FSType = fields[i+1]
+			m.SuperOptions = fields[i+3]
+		}
+
+		mounts = append(mounts, m)
+	}
+
+	return mounts, s.Err()
+}
+
+// unescapeMountInfo decodes octal escapes such as "\040" for a space.
+func unescapeMountInfo(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+
+	return b.String()
+}
+
+// parentMount returns the mount that path lives on.
+func parentMount(mounts []mountInfo, path string) (*mountInfo, error) {
+	path = filepath.Clean(path)
+
+	var parent *mountInfo
+	for i, m := range mounts {
+		if m.Mountpoint != "/" && path != m.Mountpoint && !strings.HasPrefix(path, m.Mountpoint+"/") {
+			continue
+		}
+
+		if parent == nil || len(m.Mountpoint) >= len(parent.Mountpoint) {
+			parent = &mounts[i]
+		}
+	}
+
+	if parent == nil {
+		return nil, fmt.Errorf("could not find parent mount of %s", path)
+	}
+
+	return parent, nil
+}
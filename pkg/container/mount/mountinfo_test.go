@@ -0,0 +1,49 @@
+package mount
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const sampleMountInfo = `22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+23 22 0:21 / /proc rw,nosuid,nodev,noexec,relatime shared:5 - proc proc rw
+24 22 0:22 / /run rw,nosuid,nodev shared:6 - tmpfs tmpfs rw
+25 24 0:23 / /run/containers rw - tmpfs tmpfs rw
+26 22 8:2 / /var/lib/my\040volume rw master:3 - ext4 /dev/sda2 rw
+`
+
+func TestParseMountInfo(t *testing.T) {
+	mounts, err := parseMountInfo(strings.NewReader(sampleMountInfo))
+	assert.NoError(t, err)
+	assert.Len(t, mounts, 5)
+
+	assert.Equal(t, mountInfo{Root: "/", Mountpoint: "/", Optional: "shared:1", FSType: "ext4", SuperOptions: "rw"}, mounts[0])
+	assert.Equal(t, mountInfo{Root: "/", Mountpoint: "/run/containers", Optional: "", FSType: "tmpfs", SuperOptions: "rw"}, mounts[3])
+	assert.Equal(t, mountInfo{Root: "/", Mountpoint: "/var/lib/my volume", Optional: "master:3", FSType: "ext4", SuperOptions: "rw"}, mounts[4])
+}
+
+func TestParentMount(t *testing.T) {
+	mounts, err := parseMountInfo(strings.NewReader(sampleMountInfo))
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		path     string
+		expected string
+	}{
+		{path: "/var/lib/noic/rootfs", expected: "/"},
+		{path: "/run/containers/abc/rootfs", expected: "/run/containers"},
+		{path: "/run/containersfoo", expected: "/run"},
+		{path: "/run", expected: "/run"},
+		{path: "/var/lib/my volume/rootfs/", expected: "/var/lib/my volume"},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.path, func(t *testing.T) {
+			m, err := parentMount(mounts, test.path)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, m.Mountpoint)
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package mount
+
+import (
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+type mountFlag struct {
+	clear bool
+	flag  int
+}
+
+type mountAttr struct {
+	clear bool
+	attr  uint64
+}
+
+var (
+	mountFlags = map[string]mountFlag{
+		"acl":           {false, unix.MS_POSIXACL},
+		"async":         {true, unix.MS_SYNCHRONOUS},
+		"atime":         {true, unix.MS_NOATIME},
+		"bind":          {false, unix.MS_BIND},
+		"defaults":      {false, 0},
+		"dev":           {true, unix.MS_NODEV},
+		"diratime":      {true, unix.MS_NODIRATIME},
+		"dirsync":       {false, unix.MS_DIRSYNC},
+		"exec":          {true, unix.MS_NOEXEC},
+		"iversion":      {false, unix.MS_I_VERSION},
+		"lazytime":      {false, unix.MS_LAZYTIME},
+		"loud":          {true, unix.MS_SILENT},
+		"mand":          {false, unix.MS_MANDLOCK},
+		"noacl":         {true, unix.MS_POSIXACL},
+		"noatime":       {false, unix.MS_NOATIME},
+		"nodev":         {false, unix.MS_NODEV},
+		"nodiratime":    {false, unix.MS_NODIRATIME},
+		"noexec":        {false, unix.MS_NOEXEC},
+		"noiversion":    {true, unix.MS_I_VERSION},
+		"nolazytime":    {true, unix.MS_LAZYTIME},
+		"nomand":        {true, unix.MS_MANDLOCK},
+		"norelatime":    {true, unix.MS_RELATIME},
+		"nostrictatime": {true, unix.MS_STRICTATIME},
+		"nosuid":        {false, unix.MS_NOSUID},
+		"rbind":         {false, unix.MS_BIND | unix.MS_REC},
+		"relatime":      {false, unix.MS_RELATIME},
+		"remount":       {false, unix.MS_REMOUNT},
+		"ro":            {false, unix.MS_RDONLY},
+		"rw":            {true, unix.MS_RDONLY},
+		"silent":        {false, unix.MS_SILENT},
+		"strictatime":   {false, unix.MS_STRICTATIME},
+		"suid":          {true, unix.MS_NOSUID},
+		"sync":          {false, unix.MS_SYNCHRONOUS},
+	}
+
+	// recursive attributes applied with mount_setattr(2)
+	recursiveMountAttrs = map[string]mountAttr{
+		"rro":            {false, unix.MOUNT_ATTR_RDONLY},
+		"rrw":            {true, unix.MOUNT_ATTR_RDONLY},
+		"rnosuid":        {false, unix.MOUNT_ATTR_NOSUID},
+		"rsuid":          {true, unix.MOUNT_ATTR_NOSUID},
+		"rnodev":         {false, unix.MOUNT_ATTR_NODEV},
+		"rdev":           {true, unix.MOUNT_ATTR_NODEV},
+		"rnoexec":        {false, unix.MOUNT_ATTR_NOEXEC},
+		"rexec":          {true, unix.MOUNT_ATTR_NOEXEC},
+		"rnodiratime":    {false, unix.MOUNT_ATTR_NODIRATIME},
+		"rdiratime":      {true, unix.MOUNT_ATTR_NODIRATIME},
+		"rrelatime":      {false, unix.MOUNT_ATTR_RELATIME},
+		"rnorelatime":    {true, unix.MOUNT_ATTR_RELATIME},
+		"rnoatime":       {false, unix.MOUNT_ATTR_NOATIME},
+		"ratime":         {true, unix.MOUNT_ATTR_NOATIME},
+		"rstrictatime":   {false, unix.MOUNT_ATTR_STRICTATIME},
+		"rnostrictatime": {true, unix.MOUNT_ATTR_STRICTATIME},
+		"rnosymfollow":   {false, unix.MOUNT_ATTR_NOSYMFOLLOW},
+		"rsymfollow":     {true, unix.MOUNT_ATTR_NOSYMFOLLOW},
+	}
+)
+
+type mountOptions struct {
+	// flags passed to mount(2)
+	flags int
+	// flags explicitly cleared by options such as "rw" or "suid"
+	clearedFlags int
+	// propagation flags, each applied with a separate mount(2) call
+	propagationFlags []int
+	// recursive attributes applied with mount_setattr(2)
+	recAttrSet uint64
+	recAttrClr uint64
+	// options not known as flags, passed to the filesystem
+	data []string
+}
+
+func parseMountOptions(options []string) mountOptions {
+	var opts mountOptions
+	for _, option := range options {
+		if f, exists := mountFlags[option]; exists {
+			if f.clear {
+				opts.flags &^= f.flag
+				opts.clearedFlags |= f.flag
+			} else {
+				opts.flags |= f.flag
+				opts.clearedFlags &^= f.flag
+			}
+			continue
+		}
+
+		if f, exists := mountPropagationFlags[option]; exists && f != 0 {
+			opts.propagationFlags = append(opts.propagationFlags, f)
+			continue
+		}
+
+		if a, exists := recursiveMountAttrs[option]; exists {
+			if a.attr&^unix.MOUNT_ATTR__ATIME == 0 {
+				// atime attributes are an enum rather than flags, so reset
+				// the field and then set the requested one
+				opts.recAttrClr |= unix.MOUNT_ATTR__ATIME
+				opts.recAttrSet &^= unix.MOUNT_ATTR__ATIME
+				if !a.clear {
+					opts.recAttrSet |= a.attr
+				}
+				continue
+			}
+
+			if a.clear {
+				opts.recAttrClr |= a.attr
+				opts.recAttrSet &^= a.attr
+			} else {
+				opts.recAttrSet |= a.attr
+				opts.recAttrClr &^= a.attr
+			}
+			continue
+		}
+
+		opts.data = append(opts.data, option)
+	}
+
+	return opts
+}
+
+func (o mountOptions) mountData() string {
+	return strings.Join(o.data, ",")
+}
+
+// applyPropagationAndAttributes applies the options that cannot be passed
+// with the initial mount(2) call to the mount at dest inside rootfs.
+func applyPropagationAndAttributes(rootfs, dest string, opts mountOptions) error {
+	if len(opts.propagationFlags) == 0 && opts.recAttrSet == 0 && opts.recAttrClr == 0 {
+		return nil
+	}
+
+	return withProcfd(rootfs, dest, func(procfd string) error {
+		for _, flag := range opts.propagationFlags {
+			if err := unix.Mount("", procfd, "", uintptr(flag), ""); err != nil {
+				return &os.PathError{Op: "mount propagation", Path: dest, Err: err}
+			}
+		}
+
+		if opts.recAttrSet != 0 || opts.recAttrClr != 0 {
+			attr := &unix.MountAttr{Attr_set: opts.recAttrSet, Attr_clr: opts.recAttrClr}
+			if err := unix.MountSetattr(-1, procfd, unix.AT_RECURSIVE, attr); err != nil {
+				return &os.PathError{Op: "mount_setattr", Path: dest, Err: err}
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,118 @@
+package mount
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestParseMountOptions(t *testing.T) {
+	testCases := []struct {
+		name     string
+		options  []string
+		expected mountOptions
+	}{
+		{
+			name:     "empty",
+			options:  nil,
+			expected: mountOptions{},
+		},
+		{
+			name:    "set flags and data",
+			options: []string{"nosuid", "strictatime", "mode=755", "size=65536k"},
+			expected: mountOptions{
+				flags: unix.MS_NOSUID | unix.MS_STRICTATIME,
+				data:  []string{"mode=755", "size=65536k"},
+			},
+		},
+		{
+			name:    "clear flags override earlier options",
+			options: []string{"ro", "nosuid", "rw", "suid", "noexec"},
+			expected: mountOptions{
+				flags:        unix.MS_NOEXEC,
+				clearedFlags: unix.MS_RDONLY | unix.MS_NOSUID,
+			},
+		},
+		{
+			name:    "set flags override earlier clear options",
+			options: []string{"rw", "ro"},
+			expected: mountOptions{
+				flags: unix.MS_RDONLY,
+			},
+		},
+		{
+			name:    "all mount flags are not passed as data",
+			options: []string{"dev", "exec", "atime", "noatime", "nodiratime", "sync", "dirsync", "remount", "mand", "defaults"},
+			expected: mountOptions{
+				flags:        unix.MS_NOATIME | unix.MS_NODIRATIME | unix.MS_SYNCHRONOUS | unix.MS_DIRSYNC | unix.MS_REMOUNT | unix.MS_MANDLOCK,
+				clearedFlags: unix.MS_NODEV | unix.MS_NOEXEC,
+			},
+		},
+		{
+			name:    "bind with propagation",
+			options: []string{"rbind", "rshared", "private"},
+			expected: mountOptions{
+				flags:            unix.MS_BIND | unix.MS_REC,
+				propagationFlags: []int{unix.MS_SHARED | unix.MS_REC, unix.MS_PRIVATE},
+			},
+		},
+		{
+			name:    "propagation keeps its order",
+			options: []string{"rslave", "runbindable", "shared", "slave", "unbindable", "rprivate"},
+			expected: mountOptions{
+				propagationFlags: []int{
+					unix.MS_SLAVE | unix.MS_REC,
+					unix.MS_UNBINDABLE | unix.MS_REC,
+					unix.MS_SHARED,
+					unix.MS_SLAVE,
+					unix.MS_UNBINDABLE,
+					unix.MS_PRIVATE | unix.MS_REC,
+				},
+			},
+		},
+		{
+			name:    "recursive attributes",
+			options: []string{"rbind", "rro", "rnosuid", "rnoexec", "rdev"},
+			expected: mountOptions{
+				flags:      unix.MS_BIND | unix.MS_REC,
+				recAttrSet: unix.MOUNT_ATTR_RDONLY | unix.MOUNT_ATTR_NOSUID | unix.MOUNT_ATTR_NOEXEC,
+				recAttrClr: unix.MOUNT_ATTR_NODEV,
+			},
+		},
+		{
+			name:    "recursive attribute cleared later",
+			options: []string{"rro", "rrw"},
+			expected: mountOptions{
+				recAttrClr: unix.MOUNT_ATTR_RDONLY,
+			},
+		},
+		{
+			name:    "recursive atime attributes are exclusive",
+			options: []string{"rnoatime", "rstrictatime"},
+			expected: mountOptions{
+				recAttrSet: unix.MOUNT_ATTR_STRICTATIME,
+				recAttrClr: unix.MOUNT_ATTR__ATIME,
+			},
+		},
+		{
+			name:    "recursive relatime",
+			options: []string{"rnoatime", "rrelatime"},
+			expected: mountOptions{
+				recAttrSet: unix.MOUNT_ATTR_RELATIME,
+				recAttrClr: unix.MOUNT_ATTR__ATIME,
+			},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, parseMountOptions(test.options))
+		})
+	}
+}
+
+func TestMountData(t *testing.T) {
+	opts := parseMountOptions([]string{"nosuid", "newinstance", "ptmxmode=0666", "mode=0620", "gid=5"})
+	assert.Equal(t, "newinstance,ptmxmode=0666,mode=0620,gid=5", opts.mountData())
+}
@@ -0,0 +1,139 @@
+package mount
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const (
+	// AnnotationOverlayLower lists the lower directories of the rootfs
+	// overlay, top-most first, separated by ":".
+	AnnotationOverlayLower = "org.noic.overlay.lower"
+
+	// layersManifestFilename is a file in root.path listing the lower
+	// directories, top-most first, one per line.
+	layersManifestFilename = "layers"
+)
+
+// Overlay is a rootfs assembled by noic from layered directories.
+type Overlay struct {
+	LowerDirs []string `json:"lowerDirs"`
+	UpperDir  string   `json:"upperDir"`
+	WorkDir   string   `json:"workDir"`
+	MergedDir string   `json:"mergedDir"`
+}
+
+// NewOverlay returns nil if rootPath is a plain rootfs and no lower
+// directories are given by annotations. Otherwise it creates the upper,
+// work and merged directories under dir. Relative lower directories in the
+// annotations are relative to bundlePath.
+func NewOverlay(rootPath, bundlePath string, annotations map[string]string, dir string) (*Overlay, error) {
+	lowerDirs, err := overlayLowerDirs(rootPath, bundlePath, annotations)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(lowerDirs) == 0 {
+		return nil, nil
+	}
+
+	for _, d := range lowerDirs {
+		fi, err := os.Stat(d)
+		if err != nil {
+			return nil, fmt.Errorf("invalid overlay lower directory: %s", err)
+		}
+		if !fi.IsDir() {
+			return nil, fmt.Errorf("overlay lower directory %s is not a directory", d)
+		}
+	}
+
+	o := &Overlay{
+		LowerDirs: lowerDirs,
+		UpperDir:  filepath.Join(dir, "upper"),
+		WorkDir:   filepath.Join(dir, "work"),
+		MergedDir: filepath.Join(dir, "merged"),
+	}
+
+	for _, d := range []string{o.UpperDir, o.WorkDir, o.MergedDir} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			return nil, err
+		}
+	}
+
+	return o, nil
+}
+
+func overlayLowerDirs(rootPath, bundlePath string, annotations map[string]string) ([]string, error) {
+	if lower, exists := annotations[AnnotationOverlayLower]; exists {
+		var dirs []string
+		for _, d := range strings.Split(lower, ":") {
+			if d == "" {
+				continue
+			}
+
+			if strings.Contains(d, ",") {
+				return nil, fmt.Errorf("invalid overlay lower directory %q in %s: must not contain ','", d, AnnotationOverlayLower)
+			}
+
+			if !filepath.IsAbs(d) {
+				d = filepath.Join(bundlePath, d)
+			}
+			dirs = append(dirs, filepath.Clean(d))
+		}
+
+		return dirs, nil
+	}
+
+	f, err := os.Open(filepath.Join(rootPath, layersManifestFilename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var dirs []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// the directories are passed in the lowerdir option of the mount
+		if strings.ContainsAny(line, ":,") {
+			return nil, fmt.Errorf("invalid overlay lower directory %q in %s: must not contain ':' or ','", line, f.Name())
+		}
+
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(rootPath, line)
+		}
+		dirs = append(dirs, filepath.Clean(line))
+	}
+
+	return dirs, s.Err()
+}
+
+// Mount mounts the overlay on MergedDir. MergedDir is first bind mounted onto
+// itself as a private mount, so that the overlay does not propagate to the
+// host even when the propagation of "/" is shared.
+func (o *Overlay) Mount() error {
+	if err := syscall.Mount(o.MergedDir, o.MergedDir, "bind", syscall.MS_BIND, ""); err != nil {
+		return fmt.Errorf("failed bind mount overlay rootfs %s: %s", o.MergedDir, err)
+	}
+	if err := syscall.Mount("", o.MergedDir, "", syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("failed make overlay rootfs %s private: %s", o.MergedDir, err)
+	}
+
+	data := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", strings.Join(o.LowerDirs, ":"), o.UpperDir, o.WorkDir)
+	if err := syscall.Mount("overlay", o.MergedDir, "overlay", 0, data); err != nil {
+		return fmt.Errorf("failed mount overlay rootfs %s: %s", o.MergedDir, err)
+	}
+
+	return nil
+}
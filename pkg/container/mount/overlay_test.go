@@ -0,0 +1,161 @@
+package mount
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOverlayLowerDirs(t *testing.T) {
+	tests := []struct {
+		name        string
+		manifest    *string
+		annotations map[string]string
+		want        func(root string) []string
+		wantErr     bool
+	}{
+		{
+			name: "no manifest",
+			want: func(root string) []string { return nil },
+		},
+		{
+			name:     "empty manifest",
+			manifest: stringPtr("\n# no layers\n\n"),
+			want:     func(root string) []string { return nil },
+		},
+		{
+			name:     "manifest keeps the order",
+			manifest: stringPtr("layers/3\n/abs/layer2\n\n# comment\n  layers/../layers/1  \n"),
+			want: func(root string) []string {
+				return []string{filepath.Join(root, "layers/3"), "/abs/layer2", filepath.Join(root, "layers/1")}
+			},
+		},
+		{
+			name:     "manifest with a colon",
+			manifest: stringPtr("layers/1:layers/2\n"),
+			wantErr:  true,
+		},
+		{
+			name:     "manifest with a comma",
+			manifest: stringPtr("layers/1,upperdir=/tmp\n"),
+			wantErr:  true,
+		},
+		{
+			name:        "annotation keeps the order",
+			annotations: map[string]string{AnnotationOverlayLower: "/layer/2::/layer/1:"},
+			want:        func(root string) []string { return []string{"/layer/2", "/layer/1"} },
+		},
+		{
+			name:        "annotation relative to the bundle",
+			annotations: map[string]string{AnnotationOverlayLower: "lower/2:/layer/1:../lower/0"},
+			want:        func(root string) []string { return []string{"/bundle/lower/2", "/layer/1", "/lower/0"} },
+		},
+		{
+			name:        "annotation takes precedence over the manifest",
+			manifest:    stringPtr("layers/1\n"),
+			annotations: map[string]string{AnnotationOverlayLower: "/layer/1"},
+			want:        func(root string) []string { return []string{"/layer/1"} },
+		},
+		{
+			name:        "empty annotation",
+			manifest:    stringPtr("layers/1\n"),
+			annotations: map[string]string{AnnotationOverlayLower: ""},
+			want:        func(root string) []string { return nil },
+		},
+		{
+			name:        "annotation with a comma",
+			annotations: map[string]string{AnnotationOverlayLower: "/layer/1,upperdir=/tmp"},
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			if tt.manifest != nil {
+				require.NoError(t, os.WriteFile(filepath.Join(root, layersManifestFilename), []byte(*tt.manifest), 0o644))
+			}
+
+			got, err := overlayLowerDirs(root, "/bundle", tt.annotations)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want(root), got)
+		})
+	}
+}
+
+func TestNewOverlay(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		setup    func(t *testing.T, root string)
+		wantNil  bool
+		wantErr  bool
+	}{
+		{
+			name:    "plain rootfs",
+			wantNil: true,
+		},
+		{
+			name:     "lower",
+			manifest: "lower/2\nlower/1\n",
+			setup: func(t *testing.T, root string) {
+				require.NoError(t, os.MkdirAll(filepath.Join(root, "lower/1"), 0o755))
+				require.NoError(t, os.MkdirAll(filepath.Join(root, "lower/2"), 0o755))
+			},
+		},
+		{
+			name:     "missing layer",
+			manifest: "lower/1\n",
+			wantErr:  true,
+		},
+		{
+			name:     "layer is not a directory",
+			manifest: "lower/1\n",
+			setup: func(t *testing.T, root string) {
+				require.NoError(t, os.Mkdir(filepath.Join(root, "lower"), 0o755))
+				require.NoError(t, os.WriteFile(filepath.Join(root, "lower/1"), nil, 0o644))
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			dir := filepath.Join(t.TempDir(), "overlay")
+			if tt.manifest != "" {
+				require.NoError(t, os.WriteFile(filepath.Join(root, layersManifestFilename), []byte(tt.manifest), 0o644))
+			}
+			if tt.setup != nil {
+				tt.setup(t, root)
+			}
+
+			o, err := NewOverlay(root, root, nil, dir)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			if tt.wantNil {
+				assert.Nil(t, o)
+				return
+			}
+
+			assert.Equal(t, []string{filepath.Join(root, "lower/2"), filepath.Join(root, "lower/1")}, o.LowerDirs)
+			for _, d := range []string{o.UpperDir, o.WorkDir, o.MergedDir} {
+				assert.DirExists(t, d)
+			}
+		})
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
@@ -4,42 +4,72 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"syscall"
 
 	specsgo "github.com/opencontainers/runtime-spec/specs-go"
 )
 
 var (
-	mountPropergationFlags = map[string]int{
-		"shared":     syscall.MS_SHARED,
-		"slave":      syscall.MS_SLAVE,
-		"private":    syscall.MS_PRIVATE,
-		"unbindable": syscall.MS_UNBINDABLE,
-		"":           0,
+	mountPropagationFlags = map[string]int{
+		"shared":      syscall.MS_SHARED,
+		"rshared":     syscall.MS_SHARED | syscall.MS_REC,
+		"slave":       syscall.MS_SLAVE,
+		"rslave":      syscall.MS_SLAVE | syscall.MS_REC,
+		"private":     syscall.MS_PRIVATE,
+		"rprivate":    syscall.MS_PRIVATE | syscall.MS_REC,
+		"unbindable":  syscall.MS_UNBINDABLE,
+		"runbindable": syscall.MS_UNBINDABLE | syscall.MS_REC,
+		"":            0,
 	}
 )
 
-func MountRootFs(rootfs string, spec *specsgo.Spec) error {
-	flags := syscall.MS_SLAVE | syscall.MS_REC
-	/*
-		TODO: Support rootfsMountPropagation
-		mountPropagationFlag, exists := mountPropergationFlags[spec.Linux.RootfsPropagation]
-		if !exists {
-			return fmt.Errorf("invalid rootfsPropagation: %s", spec.Linux.RootfsPropagation)
+// rootfsPropagationFlags returns the mount flags of the rootfsPropagation,
+// which is rslave when it is not set.
+func rootfsPropagationFlags(propagation string) (int, error) {
+	flags, exists := mountPropagationFlags[propagation]
+	if !exists {
+		return 0, fmt.Errorf("invalid rootfsPropagation: %s", propagation)
+	}
+
+	if flags == 0 {
+		return syscall.MS_SLAVE | syscall.MS_REC, nil
+	}
+
+	return flags, nil
+}
+
+func MountRootFs(rootfs string, spec *specsgo.Spec, idmapUserns map[int]*os.File, overlay *Overlay) error {
+	flags, err := rootfsPropagationFlags(spec.Linux.RootfsPropagation)
+	if err != nil {
+		return err
+	}
+
+	if err := syscall.Mount("", "/", "", uintptr(flags), ""); err != nil {
+		return fmt.Errorf("failed to mount rootfs: %s", err)
+	}
+
+	// The overlay is mounted only after the propagation of "/" is set, on a
+	// private mount of its own, so that it stays inside the container's mount
+	// namespace whatever the rootfsPropagation.
+	if overlay != nil {
+		if err := overlay.Mount(); err != nil {
+			return err
 		}
 
-		if mountPropagationFlag != 0 {
-			flags = mountPropagationFlag
+		// the working directory still refers to the directory under the overlay
+		if err := os.Chdir(rootfs); err != nil {
+			return err
 		}
-	*/
+	}
 
 	pwd, err := os.Getwd()
 	if err != nil {
 		return err
 	}
 
-	if err := syscall.Mount("", "/", "", uintptr(flags), ""); err != nil {
-		return fmt.Errorf("failed to mount rootfs: %s", err)
+	if err := rootfsParentMountPrivate(rootfs); err != nil {
+		return err
 	}
 
 	// bind mount for pivot_root
@@ -47,7 +77,16 @@ func MountRootFs(rootfs string, spec *specsgo.Spec) error {
 		return fmt.Errorf("failed to bind mount for pivot_root: src=%s dest=%s, %s", rootfs, rootfs, err)
 	}
 
-	if err := MountFilesystems(rootfs, spec.Mounts); err != nil {
+	dev, devSub := devMounts(spec.Mounts)
+	if err := MountFilesystems(rootfs, dev, spec.Linux.MountLabel, nil); err != nil {
+		return err
+	}
+
+	if err := MountFilesystems(rootfs, spec.Mounts, spec.Linux.MountLabel, idmapUserns); err != nil {
+		return err
+	}
+
+	if err := MountFilesystems(rootfs, devSub, spec.Linux.MountLabel, nil); err != nil {
 		return err
 	}
 
@@ -80,13 +119,56 @@ func MountRootFs(rootfs string, spec *specsgo.Spec) error {
 		return fmt.Errorf("failed remove .old after pivot_root: %s", err)
 	}
 
+	// The propagation is applied again after pivot_root, so that it affects
+	// the container's "/" rather than the mount the rootfs came from.
 	if err := syscall.Mount("", "/", "", uintptr(flags), ""); err != nil {
 		return fmt.Errorf("failed to mount rootfs: %s", err)
 	}
 
-	for _, path := range spec.Linux.MaskedPaths {
-		if err := MaskPath(path); err != nil {
-			return err
+	if err := MaskPaths("/", spec.Linux.MaskedPaths); err != nil {
+		return err
+	}
+
+	if spec.Root.Readonly {
+		if err := remountReadonly("/"); err != nil {
+			return fmt.Errorf("failed remount rootfs read-only: %s", err)
+		}
+	}
+
+	return nil
+}
+
+func remountReadonly(path string) error {
+	var s syscall.Statfs_t
+	if err := syscall.Statfs(path, &s); err != nil {
+		return &os.PathError{Op: "statfs", Path: path, Err: err}
+	}
+	flags := uintptr(s.Flags) & (syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC)
+
+	return syscall.Mount("", path, "", flags|syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY, "")
+}
+
+// rootfsParentMountPrivate makes the parent mount of rootfs private if it is shared.
+// pivot_root(2) fails when the parent mount is shared, and the bind mount of
+// rootfs would otherwise propagate back to the host.
+func rootfsParentMountPrivate(rootfs string) error {
+	mounts, err := readMountInfo()
+	if err != nil {
+		return err
+	}
+
+	parent, err := parentMount(mounts, rootfs)
+	if err != nil {
+		return err
+	}
+
+	for _, opt := range strings.Fields(parent.Optional) {
+		if strings.HasPrefix(opt, "shared:") {
+			if err := syscall.Mount("", parent.Mountpoint, "", syscall.MS_PRIVATE, ""); err != nil {
+				return fmt.Errorf("failed make parent mount %s private: %s", parent.Mountpoint, err)
+			}
+
+			return nil
 		}
 	}
 
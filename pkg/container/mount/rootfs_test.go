@@ -0,0 +1,41 @@
+package mount
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestRootfsPropagationFlags(t *testing.T) {
+	testCases := []struct {
+		name        string
+		propagation string
+		expected    int
+		wantErr     bool
+	}{
+		{name: "not set", propagation: "", expected: unix.MS_SLAVE | unix.MS_REC},
+		{name: "shared", propagation: "shared", expected: unix.MS_SHARED},
+		{name: "rshared", propagation: "rshared", expected: unix.MS_SHARED | unix.MS_REC},
+		{name: "slave", propagation: "slave", expected: unix.MS_SLAVE},
+		{name: "rslave", propagation: "rslave", expected: unix.MS_SLAVE | unix.MS_REC},
+		{name: "private", propagation: "private", expected: unix.MS_PRIVATE},
+		{name: "rprivate", propagation: "rprivate", expected: unix.MS_PRIVATE | unix.MS_REC},
+		{name: "unbindable", propagation: "unbindable", expected: unix.MS_UNBINDABLE},
+		{name: "runbindable", propagation: "runbindable", expected: unix.MS_UNBINDABLE | unix.MS_REC},
+		{name: "invalid", propagation: "rsharedd", wantErr: true},
+		{name: "mount option", propagation: "ro", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			flags, err := rootfsPropagationFlags(tc.propagation)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, flags)
+		})
+	}
+}
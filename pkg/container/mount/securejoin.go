@@ -0,0 +1,214 @@
+package mount
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const maxSymlinkLimit = 255
+
+// SecureJoin joins unsafePath to root, resolving symlinks as if root were "/",
+// so that the result never points outside of root. Components that do not
+// exist are joined lexically.
+func SecureJoin(root, unsafePath string) (string, error) {
+	root = filepath.Clean(root)
+
+	var path strings.Builder
+	linksWalked := 0
+	for unsafePath != "" {
+		if linksWalked > maxSymlinkLimit {
+			return "", &os.PathError{Op: "securejoin", Path: root + "/" + unsafePath, Err: syscall.ELOOP}
+		}
+
+		var p string
+		if i := strings.IndexRune(unsafePath, '/'); i == -1 {
+			p, unsafePath = unsafePath, ""
+		} else {
+			p, unsafePath = unsafePath[:i], unsafePath[i+1:]
+		}
+
+		// Lexically clean the path so far with "/" as the root, which
+		// keeps ".." from stepping outside of root.
+		cleanP := filepath.Clean("/" + path.String() + p)
+		if cleanP == "/" {
+			path.Reset()
+			continue
+		}
+		fullP := filepath.Clean(root + cleanP)
+
+		fi, err := os.Lstat(fullP)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+
+		// non-existent components are treated the same as non-symlinks
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			path.WriteString(p + "/")
+			continue
+		}
+
+		linksWalked++
+		dest, err := os.Readlink(fullP)
+		if err != nil {
+			return "", err
+		}
+
+		// absolute symlinks restart from root, relative ones from the
+		// directory containing the symlink
+		if filepath.IsAbs(dest) {
+			path.Reset()
+		}
+		unsafePath = dest + "/" + unsafePath
+	}
+
+	return filepath.Join(root, filepath.Clean("/"+path.String())), nil
+}
+
+// openInRoot opens unsafePath as an O_PATH handle, resolving it inside root.
+func openInRoot(root, unsafePath string) (*os.File, error) {
+	rootFd, err := unix.Open(root, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: root, Err: err}
+	}
+	defer unix.Close(rootFd)
+
+	fd, err := unix.Openat2(rootFd, unsafePath, &unix.OpenHow{
+		Flags:   unix.O_PATH | unix.O_CLOEXEC,
+		Resolve: unix.RESOLVE_IN_ROOT | unix.RESOLVE_NO_MAGICLINKS,
+	})
+	if err == nil {
+		return os.NewFile(uintptr(fd), filepath.Join(root, unsafePath)), nil
+	}
+	if !errors.Is(err, unix.ENOSYS) {
+		return nil, &os.PathError{Op: "openat2", Path: filepath.Join(root, unsafePath), Err: err}
+	}
+
+	// openat2(2) is not available before Linux 5.6
+	path, err := SecureJoin(root, unsafePath)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err = unix.Open(path, unix.O_PATH|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+// withProcfd opens unsafePath inside root and calls fn with its /proc/self/fd path,
+// so that a symlink swapped in after the path was resolved cannot redirect fn.
+func withProcfd(root, unsafePath string, fn func(procfd string) error) error {
+	root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+
+	f, err := openInRoot(root, unsafePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	procfd := fmt.Sprintf("/proc/self/fd/%d", f.Fd())
+	realpath, err := os.Readlink(procfd)
+	if err != nil {
+		return err
+	}
+
+	if root != "/" && realpath != root && !strings.HasPrefix(realpath, root+"/") {
+		return fmt.Errorf("possibly malicious path detected: %s resolved to %s outside of %s", unsafePath, realpath, root)
+	}
+
+	return fn(procfd)
+}
+
+// mkdirAllInRoot creates unsafePath and any missing parents inside root.
+// Existing components are opened without following symlinks, so a symlink
+// swapped in after resolution makes it fail rather than escape root.
+func mkdirAllInRoot(root, unsafePath string, mode uint32) (*os.File, error) {
+	path, err := SecureJoin(root, unsafePath)
+	if err != nil {
+		return nil, err
+	}
+
+	rel, err := filepath.Rel(filepath.Clean(root), path)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := unix.Open(root, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: root, Err: err}
+	}
+
+	current := root
+	for _, name := range strings.Split(rel, "/") {
+		if name == "" || name == "." {
+			continue
+		}
+		current = filepath.Join(current, name)
+
+		if err := unix.Mkdirat(fd, name, mode); err != nil && !errors.Is(err, unix.EEXIST) {
+			unix.Close(fd)
+			return nil, &os.PathError{Op: "mkdirat", Path: current, Err: err}
+		}
+
+		next, err := unix.Openat(fd, name, unix.O_PATH|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+		unix.Close(fd)
+		if err != nil {
+			return nil, &os.PathError{Op: "openat", Path: current, Err: err}
+		}
+		fd = next
+	}
+
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+// createInRoot creates a directory or an empty file at unsafePath inside root
+// to be used as a mount point.
+func createInRoot(root, unsafePath string, isDir bool) error {
+	if isDir {
+		f, err := mkdirAllInRoot(root, unsafePath, 0o755)
+		if err != nil {
+			return err
+		}
+
+		return f.Close()
+	}
+
+	path, err := SecureJoin(root, unsafePath)
+	if err != nil {
+		return err
+	}
+
+	rel, err := filepath.Rel(filepath.Clean(root), path)
+	if err != nil {
+		return err
+	}
+
+	dir, err := mkdirAllInRoot(root, filepath.Dir(rel), 0o755)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	// O_EXCL leaves an existing file alone, so that e.g. a FIFO placed at
+	// the destination by the image is not opened
+	fd, err := unix.Openat(int(dir.Fd()), filepath.Base(rel), unix.O_CREAT|unix.O_EXCL|unix.O_NOFOLLOW|unix.O_RDONLY|unix.O_CLOEXEC, 0o755)
+	if errors.Is(err, unix.EEXIST) {
+		return nil
+	}
+	if err != nil {
+		return &os.PathError{Op: "openat", Path: path, Err: err}
+	}
+
+	return unix.Close(fd)
+}
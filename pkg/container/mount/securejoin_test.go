@@ -0,0 +1,94 @@
+package mount
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecureJoin(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "usr/lib"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "run"), 0o755))
+	require.NoError(t, os.Symlink("/run", filepath.Join(root, "var-run")))
+	require.NoError(t, os.Symlink("../../..", filepath.Join(root, "usr/lib/up")))
+	require.NoError(t, os.Symlink("/etc/passwd", filepath.Join(root, "passwd")))
+	require.NoError(t, os.Symlink("lib", filepath.Join(root, "usr/lib64")))
+	require.NoError(t, os.Symlink("loop", filepath.Join(root, "loop")))
+
+	testCases := []struct {
+		name        string
+		unsafePath  string
+		expected    string
+		expectError bool
+	}{
+		{name: "plain path", unsafePath: "/usr/lib", expected: "/usr/lib"},
+		{name: "non-existent path", unsafePath: "/usr/share/foo", expected: "/usr/share/foo"},
+		{name: "dot dot stays in root", unsafePath: "/../../etc", expected: "/etc"},
+		{name: "absolute symlink", unsafePath: "/var-run/foo", expected: "/run/foo"},
+		{name: "relative symlink", unsafePath: "/usr/lib64/foo", expected: "/usr/lib/foo"},
+		{name: "relative symlink escaping root", unsafePath: "/usr/lib/up/etc", expected: "/etc"},
+		{name: "absolute symlink to host file", unsafePath: "/passwd", expected: "/etc/passwd"},
+		{name: "symlink loop", unsafePath: "/loop", expectError: true},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := SecureJoin(root, test.unsafePath)
+			if test.expectError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, filepath.Join(root, test.expected), p)
+		})
+	}
+}
+
+func TestCreateInRoot(t *testing.T) {
+	root := t.TempDir()
+	host := t.TempDir()
+	require.NoError(t, os.Symlink(host, filepath.Join(root, "evil")))
+
+	require.NoError(t, createInRoot(root, "/evil/dir", true))
+	require.NoError(t, createInRoot(root, "/evil/file", false))
+
+	entries, err := os.ReadDir(host)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	fi, err := os.Stat(filepath.Join(root, host, "dir"))
+	assert.NoError(t, err)
+	assert.True(t, fi.IsDir())
+
+	fi, err = os.Stat(filepath.Join(root, host, "file"))
+	assert.NoError(t, err)
+	assert.True(t, fi.Mode().IsRegular())
+}
+
+func TestCreateInRoot_ExistingFIFO(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, syscall.Mkfifo(filepath.Join(root, "fifo"), 0o644))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- createInRoot(root, "/fifo", false)
+	}()
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("createInRoot opened the existing FIFO")
+	}
+
+	fi, err := os.Lstat(filepath.Join(root, "fifo"))
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeNamedPipe, fi.Mode().Type())
+}
@@ -5,8 +5,10 @@ import (
 	"net"
 	"os"
 	"os/exec"
+	"strings"
 	"syscall"
 
+	"github.com/mrtc0/noic/pkg/container/mount"
 	"github.com/opencontainers/runtime-spec/specs-go"
 )
 
@@ -65,6 +67,29 @@ func (c Container) NewParentProcess() (*exec.Cmd, *os.File, error) {
 		cmd.Env = append(cmd.Env, "_NOIC_CONSOLE_FD=4")
 	}
 
+	var idmapFds []string
+	for i, m := range c.Spec.Mounts {
+		if len(m.UIDMappings) == 0 && len(m.GIDMappings) == 0 {
+			continue
+		}
+
+		userns, err := mount.NewIDMapUserns(m.UIDMappings, m.GIDMappings)
+		if err != nil {
+			for _, f := range cmd.ExtraFiles {
+				f.Close()
+			}
+			writePipe.Close()
+			return nil, nil, err
+		}
+
+		cmd.ExtraFiles = append(cmd.ExtraFiles, userns)
+		// ExtraFiles start at fd 3
+		idmapFds = append(idmapFds, fmt.Sprintf("%d:%d", i, 2+len(cmd.ExtraFiles)))
+	}
+	if len(idmapFds) > 0 {
+		cmd.Env = append(cmd.Env, "_NOIC_IDMAP_FDS="+strings.Join(idmapFds, ","))
+	}
+
 	cmd.Dir = c.Root
 	cmd.Env = append(cmd.Env, c.Spec.Process.Env...)
 
@@ -0,0 +1,40 @@
+package processes
+
+import (
+	"fmt"
+	"syscall"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// credentials returns the uid, the gid and the supplementary groups of user.
+func credentials(user specs.User) (uid, gid int, groups []int) {
+	groups = make([]int, 0, len(user.AdditionalGids))
+	for _, g := range user.AdditionalGids {
+		groups = append(groups, int(g))
+	}
+
+	return int(user.UID), int(user.GID), groups
+}
+
+func SetupUser(user specs.User) error {
+	uid, gid, groups := credentials(user)
+
+	if err := syscall.Setgroups(groups); err != nil {
+		return fmt.Errorf("failed setgroups: %s", err)
+	}
+
+	if err := syscall.Setresgid(gid, gid, gid); err != nil {
+		return fmt.Errorf("failed setgid %d: %s", gid, err)
+	}
+
+	if err := syscall.Setresuid(uid, uid, uid); err != nil {
+		return fmt.Errorf("failed setuid %d: %s", uid, err)
+	}
+
+	if user.Umask != nil {
+		syscall.Umask(int(*user.Umask))
+	}
+
+	return nil
+}
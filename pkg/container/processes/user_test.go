@@ -0,0 +1,86 @@
+package processes
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCredentials(t *testing.T) {
+	tests := []struct {
+		name       string
+		user       specs.User
+		wantUID    int
+		wantGID    int
+		wantGroups []int
+	}{
+		{
+			name:       "root",
+			user:       specs.User{},
+			wantGroups: []int{},
+		},
+		{
+			name:       "user without additional groups",
+			user:       specs.User{UID: 1000, GID: 1000},
+			wantUID:    1000,
+			wantGID:    1000,
+			wantGroups: []int{},
+		},
+		{
+			name:       "user with additional groups",
+			user:       specs.User{UID: 1000, GID: 100, AdditionalGids: []uint32{5, 27}},
+			wantUID:    1000,
+			wantGID:    100,
+			wantGroups: []int{5, 27},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uid, gid, groups := credentials(tt.user)
+			assert.Equal(t, tt.wantUID, uid)
+			assert.Equal(t, tt.wantGID, gid)
+			assert.Equal(t, tt.wantGroups, groups)
+		})
+	}
+}
+
+// TestSetupUser_Helper switches the user in a child process, since the
+// credentials cannot be restored afterwards.
+func TestSetupUser_Helper(t *testing.T) {
+	if os.Getenv("NOIC_TEST_SETUP_USER") != "1" {
+		t.Skip("run by TestSetupUser")
+	}
+
+	umask := uint32(0o027)
+	user := specs.User{UID: 1000, GID: 100, AdditionalGids: []uint32{5, 27}, Umask: &umask}
+	if err := SetupUser(user); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	groups, _ := syscall.Getgroups()
+	fmt.Printf("uid=%d euid=%d gid=%d egid=%d groups=%v umask=%o\n",
+		syscall.Getuid(), syscall.Geteuid(), syscall.Getgid(), syscall.Getegid(), groups, syscall.Umask(0))
+	os.Exit(0)
+}
+
+func TestSetupUser(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSetupUser_Helper$")
+	cmd.Env = append(os.Environ(), "NOIC_TEST_SETUP_USER=1")
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	assert.Equal(t, "uid=1000 euid=1000 gid=100 egid=100 groups=[5 27] umask=27", strings.TrimSpace(string(out)))
+}
@@ -0,0 +1,76 @@
+package selinux
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/mrtc0/noic/pkg/container/lsm"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	selinuxfsMountPoints = []string{"/sys/fs/selinux", "/selinux"}
+
+	enabled     bool
+	enabledOnce sync.Once
+)
+
+// Enabled reports whether selinuxfs is mounted on the host.
+// The result is cached on first use, since selinuxfs is not visible after pivot_root.
+func Enabled() bool {
+	enabledOnce.Do(func() {
+		enabled = selinuxfsMounted()
+	})
+
+	return enabled
+}
+
+func selinuxfsMounted() bool {
+	for _, path := range selinuxfsMountPoints {
+		var s unix.Statfs_t
+		if err := unix.Statfs(path, &s); err != nil {
+			continue
+		}
+
+		if s.Type == unix.SELINUX_MAGIC {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetExecLabel sets the label the process will transition to on the next execve.
+func SetExecLabel(label string) error {
+	if label == "" || !Enabled() {
+		return nil
+	}
+
+	if err := lsm.WriteExecAttr("selinux", label); err != nil {
+		return fmt.Errorf("failed set selinux exec label %s: %s", label, err)
+	}
+
+	return nil
+}
+
+// FormatMountLabel appends the context= option for mountLabel to the mount data.
+func FormatMountLabel(data, mountLabel string) string {
+	if !Enabled() {
+		return data
+	}
+
+	return formatMountLabel(data, mountLabel)
+}
+
+func formatMountLabel(data, mountLabel string) string {
+	if mountLabel == "" {
+		return data
+	}
+
+	context := fmt.Sprintf(`context="%s"`, mountLabel)
+	if data == "" {
+		return context
+	}
+
+	return data + "," + context
+}
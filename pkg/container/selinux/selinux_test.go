@@ -0,0 +1,43 @@
+package selinux
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatMountLabel(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       string
+		mountLabel string
+		want       string
+	}{
+		{
+			name:       "empty data",
+			mountLabel: "system_u:object_r:container_file_t:s0:c1,c2",
+			want:       `context="system_u:object_r:container_file_t:s0:c1,c2"`,
+		},
+		{
+			name:       "existing data",
+			data:       "mode=755,size=65536k",
+			mountLabel: "system_u:object_r:container_file_t:s0",
+			want:       `mode=755,size=65536k,context="system_u:object_r:container_file_t:s0"`,
+		},
+		{
+			name: "empty label",
+			data: "mode=755",
+			want: "mode=755",
+		},
+		{
+			name: "empty data and label",
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, formatMountLabel(tt.data, tt.mountLabel))
+		})
+	}
+}